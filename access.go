@@ -0,0 +1,422 @@
+package marvin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminRole is the role that has every permission.
+const AdminRole = "admin"
+
+// PermissionManageRoles is the permission needed to grant and revoke roles.
+const PermissionManageRoles = "roles.manage"
+
+// accessNamespace is the brain namespace roles are stored in.
+const accessNamespace = "access"
+
+// maxAuditEntries is the number of entries kept in the `audit` namespace;
+// newer entries replace the oldest ones.
+const maxAuditEntries = 1000
+
+// AccessConfig describes the roles and role assignments the robot's access
+// control is bootstrapped with, as passed to Robot.ConfigureAccess.
+type AccessConfig struct {
+	// Admins are the IDs of the users who have the admin role.
+	Admins []string `json:"admins"`
+
+	// Roles maps the names of roles to the permissions they grant. A
+	// permission ending in `.*` grants all permissions with that prefix,
+	// and `*` grants every permission.
+	Roles map[string][]string `json:"roles"`
+
+	// Users maps the IDs of users to the roles they have.
+	Users map[string][]string `json:"users"`
+}
+
+// Auditor describes a brain that keeps an audit log itself.
+type Auditor interface {
+	Audit(userID string, action string, detail string) error
+}
+
+// auditEntry describes an entry in the audit log, as stored in the brain.
+type auditEntry struct {
+	Action string    `json:"action"`
+	Detail string    `json:"detail"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+}
+
+// addAccessCommands adds the built-in commands managing roles. Granting and
+// revoking roles requires PermissionManageRoles, as does listing the roles
+// of another user.
+func (r *Robot) addAccessCommands() error {
+	manage := RequirePermission(PermissionManageRoles)
+
+	commands := []struct {
+		command *Command
+		options []ListenerOption
+	}{
+		{
+			command: &Command{
+				Name:        "grant",
+				Args:        []Arg{{Name: "user"}, {Name: "role"}},
+				Category:    "Access",
+				Description: "Gives a user a role.",
+				Examples:    []string{"grant @ford deployer"},
+				Handler:     r.grantCommand,
+			},
+			options: []ListenerOption{manage},
+		},
+		{
+			command: &Command{
+				Name:        "revoke",
+				Args:        []Arg{{Name: "user"}, {Name: "role"}},
+				Category:    "Access",
+				Description: "Takes a role away from a user.",
+				Examples:    []string{"revoke @ford deployer"},
+				Handler:     r.revokeCommand,
+			},
+			options: []ListenerOption{manage},
+		},
+		{
+			command: &Command{
+				Name:        "roles",
+				Args:        []Arg{{Name: "user", Optional: true}},
+				Category:    "Access",
+				Description: "Lists your roles, or those of another user.",
+				Examples:    []string{"roles", "roles @ford"},
+				Handler:     r.rolesCommand,
+			},
+		},
+	}
+
+	for _, c := range commands {
+		if _, err := r.Command(c.command, c.options...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// audit records an action in the audit log. Brains that are an Auditor
+// keep the log themselves; other brains store it in the `audit` namespace,
+// which holds the latest maxAuditEntries entries.
+func (r *Robot) audit(userID string, action string, detail string) {
+	log.Printf("marvin: audit: %s %s %s", userID, action, detail)
+
+	var err error
+	if auditor, ok := r.Brain.(Auditor); ok {
+		err = auditor.Audit(userID, action, detail)
+	} else {
+		err = r.storeAudit(&auditEntry{Action: action, Detail: detail, Time: time.Now().UTC(), User: userID})
+	}
+
+	if err != nil {
+		log.Printf("marvin: error writing audit log: %s", err)
+	}
+}
+
+// storeAudit stores an entry in the `audit` namespace, which is used as a
+// ring of maxAuditEntries entries: `seq` counts the entries stored so far,
+// and every entry replaces the one stored maxAuditEntries entries before it.
+func (r *Robot) storeAudit(entry *auditEntry) error {
+	r.auditMu.Lock()
+	defer r.auditMu.Unlock()
+
+	store := r.Namespace("audit")
+
+	var seq int
+	if err := store.Get("seq", &seq); err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if err := store.Set(fmt.Sprintf("entry:%d", seq%maxAuditEntries), entry); err != nil {
+		return err
+	}
+
+	return store.Set("seq", seq+1)
+}
+
+// authorize checks that the request's user may run the listener in the
+// request's channel.
+func (r *Robot) authorize(l *Listener, req *Request) error {
+	if !l.allowedIn(req.Message.Channel) {
+		return ErrChannelNotAllowed
+	}
+
+	if l.permission == "" {
+		return nil
+	}
+
+	if req.Message.User == nil {
+		return ErrPermissionDenied
+	}
+
+	ok, err := r.HasPermission(req.Message.User.ID, l.permission)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// deny tells the request's user that they may not run the listener, and
+// records the denial in the audit log.
+func (r *Robot) deny(l *Listener, req *Request, err error) {
+	if err != ErrChannelNotAllowed && err != ErrPermissionDenied {
+		r.handleError(req, err)
+		return
+	}
+
+	userID := ""
+	if req.Message.User != nil {
+		userID = req.Message.User.ID
+	}
+
+	channelID := ""
+	if req.Message.Channel != nil {
+		channelID = req.Message.Channel.ID
+	}
+
+	name := l.name
+	if name == "" {
+		name = l.regex.String()
+	}
+
+	r.audit(userID, "denied", fmt.Sprintf("%s in %s: %s", name, channelID, err))
+
+	text := "Sorry, I can't do that in this channel."
+	if err == ErrPermissionDenied {
+		text = fmt.Sprintf("Sorry, you need the `%s` permission to do that.", l.permission)
+	}
+
+	if err := req.Reply(text); err != nil {
+		r.handleError(req, err)
+	}
+}
+
+// grantCommand gives a user a role.
+func (r *Robot) grantCommand(req *Request, args Args) error {
+	user, err := r.lookupUser(args.String("user"))
+	if err != nil {
+		return req.Reply(fmt.Sprintf("I don't know who %s is.", args.String("user")))
+	}
+
+	role := args.String("role")
+	if err := r.Grant(user.ID, role); err == ErrUnknownRole {
+		return req.Reply(fmt.Sprintf("There's no role called %q.", role))
+	} else if err != nil {
+		return err
+	}
+
+	r.audit(req.Message.User.ID, "grant", role+" to "+user.ID)
+	return req.Reply(fmt.Sprintf("OK, @%s now has the %s role.", user.Name, role))
+}
+
+// lookupUser resolves a user mention such as `@ford` through the adapter.
+// Adapters that cannot look up users are assumed to be given user IDs.
+func (r *Robot) lookupUser(name string) (*User, error) {
+	name = strings.TrimPrefix(name, "@")
+
+	directory, ok := r.adapter.(Directory)
+	if !ok {
+		return &User{ID: name, Name: name}, nil
+	}
+
+	user, ok := directory.UserByName(name)
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	return user, nil
+}
+
+// revokeCommand takes a role away from a user.
+func (r *Robot) revokeCommand(req *Request, args Args) error {
+	user, err := r.lookupUser(args.String("user"))
+	if err != nil {
+		return req.Reply(fmt.Sprintf("I don't know who %s is.", args.String("user")))
+	}
+
+	role := args.String("role")
+	if err := r.Revoke(user.ID, role); err == ErrRoleNotGranted {
+		return req.Reply(fmt.Sprintf("@%s doesn't have the %s role.", user.Name, role))
+	} else if err != nil {
+		return err
+	}
+
+	r.audit(req.Message.User.ID, "revoke", role+" from "+user.ID)
+	return req.Reply(fmt.Sprintf("OK, @%s no longer has the %s role.", user.Name, role))
+}
+
+// rolesCommand lists the roles of the request's user, or of another user,
+// which requires PermissionManageRoles.
+func (r *Robot) rolesCommand(req *Request, args Args) error {
+	user := req.Message.User
+	if user == nil {
+		return ErrUnknownUser
+	}
+
+	if args.Has("user") {
+		other, err := r.lookupUser(args.String("user"))
+		if err != nil {
+			return req.Reply(fmt.Sprintf("I don't know who %s is.", args.String("user")))
+		}
+
+		if other.ID != user.ID {
+			ok, err := r.HasPermission(user.ID, PermissionManageRoles)
+			if err != nil {
+				return err
+			} else if !ok {
+				r.audit(user.ID, "denied", "roles of "+other.ID+": "+ErrPermissionDenied.Error())
+				return req.Reply(fmt.Sprintf("Sorry, you need the `%s` permission to do that.", PermissionManageRoles))
+			}
+		}
+
+		user = other
+	}
+
+	roles, err := r.Roles(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(roles) == 0 {
+		return req.Reply(fmt.Sprintf("@%s has no roles.", user.Name))
+	}
+
+	return req.Reply(fmt.Sprintf("@%s has the roles: %s.", user.Name, strings.Join(roles, ", ")))
+}
+
+// ConfigureAccess bootstraps the robot's roles from the given config. The
+// roles in the config replace stored roles of the same name, and the users
+// in the config are given their roles in addition to any they already have.
+func (r *Robot) ConfigureAccess(config AccessConfig) error {
+	store := r.Namespace(accessNamespace)
+
+	for role, permissions := range config.Roles {
+		if err := store.Set("role:"+role, permissions); err != nil {
+			return err
+		}
+	}
+
+	for _, userID := range config.Admins {
+		if err := r.Grant(userID, AdminRole); err != nil {
+			return err
+		}
+	}
+
+	for userID, roles := range config.Users {
+		for _, role := range roles {
+			if err := r.Grant(userID, role); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Grant gives the user with the given ID a role.
+func (r *Robot) Grant(userID string, role string) error {
+	store := r.Namespace(accessNamespace)
+
+	if role != AdminRole {
+		var permissions []string
+		if err := store.Get("role:"+role, &permissions); err == ErrNotFound {
+			return ErrUnknownRole
+		} else if err != nil {
+			return err
+		}
+	}
+
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
+	roles, err := r.Roles(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range roles {
+		if existing == role {
+			return nil
+		}
+	}
+
+	roles = append(roles, role)
+	sort.Strings(roles)
+	return store.Set("user:"+userID, roles)
+}
+
+// HasPermission returns whether one of the roles of the user with the
+// given ID grants the permission.
+func (r *Robot) HasPermission(userID string, permission string) (bool, error) {
+	roles, err := r.Roles(userID)
+	if err != nil {
+		return false, err
+	}
+
+	store := r.Namespace(accessNamespace)
+	for _, role := range roles {
+		if role == AdminRole {
+			return true, nil
+		}
+
+		var permissions []string
+		if err := store.Get("role:"+role, &permissions); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		for _, p := range permissions {
+			if p == "*" || p == permission || (strings.HasSuffix(p, ".*") && strings.HasPrefix(permission, strings.TrimSuffix(p, "*"))) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Revoke takes a role away from the user with the given ID. It returns
+// ErrRoleNotGranted if they do not have the role.
+func (r *Robot) Revoke(userID string, role string) error {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
+	roles, err := r.Roles(userID)
+	if err != nil {
+		return err
+	}
+
+	kept := []string{}
+	for _, existing := range roles {
+		if existing != role {
+			kept = append(kept, existing)
+		}
+	}
+
+	if len(kept) == len(roles) {
+		return ErrRoleNotGranted
+	}
+
+	return r.Namespace(accessNamespace).Set("user:"+userID, kept)
+}
+
+// Roles returns the roles of the user with the given ID.
+func (r *Robot) Roles(userID string) ([]string, error) {
+	var roles []string
+	if err := r.Namespace(accessNamespace).Get("user:"+userID, &roles); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,240 @@
+package marvin_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func newAccessRobot(t *testing.T) (*marvin.Robot, *mock.Adapter) {
+	adapter := mock.NewAdapter()
+	adapter.Users = []*marvin.User{{ID: "U1", Name: "zaphod"}, {ID: "4321", Name: "someperson"}}
+
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	err := robot.ConfigureAccess(marvin.AccessConfig{
+		Admins: []string{"U1"},
+		Roles: map[string][]string{
+			"deployer": {"deploy.*"},
+			"viewer":   {"logs"},
+		},
+		Users: map[string][]string{"4321": {"viewer"}},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureAccess should not have returned an error, got %s", err)
+	}
+
+	return robot, adapter
+}
+
+func TestHasPermission(t *testing.T) {
+	robot, _ := newAccessRobot(t)
+	defer robot.Close()
+
+	tests := []struct {
+		user       string
+		permission string
+		expected   bool
+	}{
+		{"U1", "anything", true},
+		{"4321", "logs", true},
+		{"4321", "deploy.api", false},
+		{"U2", "logs", false},
+	}
+
+	for _, test := range tests {
+		ok, err := robot.HasPermission(test.user, test.permission)
+		if err != nil || ok != test.expected {
+			t.Errorf("%s %s: expected %t, got %t, %v", test.user, test.permission, test.expected, ok, err)
+		}
+	}
+
+	robot.Grant("4321", "deployer")
+	if ok, _ := robot.HasPermission("4321", "deploy.api"); !ok {
+		t.Error("Granting a role should have granted its permissions")
+	}
+
+	robot.Revoke("4321", "deployer")
+	if ok, _ := robot.HasPermission("4321", "deploy.api"); ok {
+		t.Error("Revoking a role should have revoked its permissions")
+	}
+
+	if err := robot.Grant("4321", "wizard"); err != marvin.ErrUnknownRole {
+		t.Errorf("Grant should have returned ErrUnknownRole, got %v", err)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	deployed := 0
+	robot.Respond("^deploy", func(*marvin.Request) { deployed++ },
+		marvin.RequirePermission("deploy.production"), marvin.WithName("deploy"))
+
+	fallback := false
+	robot.Fallback(func(*marvin.Request) error {
+		fallback = true
+		return nil
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	flush(t, robot, adapter, "1234")
+
+	if deployed != 0 || fallback {
+		t.Error("The listener should not have run, nor the fallback")
+	}
+
+	if len(adapter.Replies) != 1 || adapter.Replies[0] != "Sorry, you need the `deploy.production` permission to do that." {
+		t.Errorf("Expected a polite denial, got %q", adapter.Replies)
+	}
+
+	if keys, _ := robot.Namespace("audit").Keys("entry:"); len(keys) != 1 {
+		t.Errorf("Expected the denial to be audited, got %v", keys)
+	}
+
+	robot.Grant("4321", "deployer")
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	flush(t, robot, adapter, "1234")
+
+	if deployed != 1 {
+		t.Error("The listener should have run once the user had the permission")
+	}
+}
+
+func TestAuditRing(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	robot.Respond("^deploy", func(*marvin.Request) {},
+		marvin.RequirePermission("deploy.production"), marvin.WithName("deploy"))
+
+	// The audit namespace holds the latest 1000 entries. Flushing every
+	// now and then keeps the channel's queue from filling up.
+	for i := 1; i <= 1002; i++ {
+		adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+		if i%50 == 0 {
+			flush(t, robot, adapter, "1234")
+		}
+	}
+	flush(t, robot, adapter, "1234")
+
+	store := robot.Namespace("audit")
+	if keys, _ := store.Keys("entry:"); len(keys) != 1000 {
+		t.Errorf("Expected 1000 audit entries, got %d", len(keys))
+	}
+
+	var seq int
+	if store.Get("seq", &seq); seq != 1002 {
+		t.Errorf("Expected 1002 entries to have been audited, got %d", seq)
+	}
+}
+
+func TestChannelLists(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	heard := map[string]int{}
+	robot.Hear("ping", func(r *marvin.Request) { heard[r.Message.Channel.ID]++ }, marvin.DenyChannels("random"))
+	robot.Respond("^ops", func(r *marvin.Request) { heard[r.Message.Channel.ID]++ }, marvin.AllowChannels("ops"))
+
+	for _, channel := range []string{"ops", "random"} {
+		adapter.PushMessage(newTestMessage(channel, "ping"))
+		adapter.PushMessage(newTestMessage(channel, "marvin ops"))
+		flush(t, robot, adapter, channel)
+	}
+
+	if heard["ops"] != 2 || heard["random"] != 0 {
+		t.Errorf("Expected the listeners to run in ops only, got %v", heard)
+	}
+
+	if len(adapter.Replies) != 1 || adapter.Replies[0] != "Sorry, I can't do that in this channel." {
+		t.Errorf("Expected a single denial, for the direct listener, got %q", adapter.Replies)
+	}
+}
+
+func TestGrantCommand(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	admin := newTestMessage("1234", "marvin grant @someperson deployer")
+	admin.User = &marvin.User{ID: "U1", Name: "zaphod"}
+
+	adapter.PushMessage(newTestMessage("1234", "marvin grant @someperson admin"))
+	adapter.PushMessage(admin)
+	adapter.PushMessage(newTestMessage("1234", "marvin help"))
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 3 {
+		t.Fatalf("Expected 3 replies, got %q", adapter.Replies)
+	}
+
+	if !strings.HasPrefix(adapter.Replies[0], "Sorry, you need") {
+		t.Errorf("Expected the user to be denied, got %q", adapter.Replies[0])
+	}
+
+	if adapter.Replies[1] != "OK, @someperson now has the deployer role." {
+		t.Errorf("Expected the admin to grant the role, got %q", adapter.Replies[1])
+	}
+
+	if strings.Contains(adapter.Replies[2], "grant") {
+		t.Errorf("Help should not list commands the user cannot run, got %q", adapter.Replies[2])
+	}
+
+	if roles, _ := robot.Roles("4321"); len(roles) != 2 || roles[0] != "deployer" {
+		t.Errorf("Expected the user to have the deployer role, got %v", roles)
+	}
+}
+
+func TestRevokeCommand(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	for _, text := range []string{"marvin revoke @someperson viewer", "marvin revoke @someperson viewer"} {
+		m := newTestMessage("1234", text)
+		m.User = &marvin.User{ID: "U1", Name: "zaphod"}
+		adapter.PushMessage(m)
+	}
+	flush(t, robot, adapter, "1234")
+
+	expected := []string{"OK, @someperson no longer has the viewer role.", "@someperson doesn't have the viewer role."}
+	if len(adapter.Replies) != 2 || adapter.Replies[0] != expected[0] || adapter.Replies[1] != expected[1] {
+		t.Errorf("Expected replies %q, got %q", expected, adapter.Replies)
+	}
+
+	if err := robot.Revoke("4321", "viewer"); err != marvin.ErrRoleNotGranted {
+		t.Errorf("Revoke should have returned ErrRoleNotGranted, got %v", err)
+	}
+}
+
+func TestRolesCommand(t *testing.T) {
+	robot, adapter := newAccessRobot(t)
+	defer robot.Close()
+
+	admin := newTestMessage("1234", "marvin roles @someperson")
+	admin.User = &marvin.User{ID: "U1", Name: "zaphod"}
+
+	adapter.PushMessage(newTestMessage("1234", "marvin roles"))
+	adapter.PushMessage(newTestMessage("1234", "marvin roles @zaphod"))
+	adapter.PushMessage(admin)
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 3 {
+		t.Fatalf("Expected 3 replies, got %q", adapter.Replies)
+	}
+
+	if adapter.Replies[0] != "@someperson has the roles: viewer." {
+		t.Errorf("Expected the user to see their own roles, got %q", adapter.Replies[0])
+	}
+
+	if !strings.HasPrefix(adapter.Replies[1], "Sorry, you need") {
+		t.Errorf("Expected the user to be denied the roles of another user, got %q", adapter.Replies[1])
+	}
+
+	if adapter.Replies[2] != "@someperson has the roles: viewer." {
+		t.Errorf("Expected the admin to see the roles of another user, got %q", adapter.Replies[2])
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -16,18 +20,98 @@ import (
 
 var addFormattingRegexp = regexp.MustCompile(`([@#])([^\s:]+)`)
 var removeFormattingRegexp = regexp.MustCompile(`<([@#!])?([^>|]+)(?:\|([^>]+))?>`)
+var userIDRegexp = regexp.MustCompile(`^[UW][A-Z0-9]{2,}$`)
+
+// Default settings.
+const (
+	DefaultMaxRetries       = 5
+	DefaultPingInterval     = 30 * time.Second
+	DefaultPongTimeout      = 10 * time.Second
+	DefaultQueueSize        = 256
+	DefaultReconnectBackoff = time.Second
+	DefaultRetryBackoff     = 500 * time.Millisecond
+	DefaultWriteTimeout     = 10 * time.Second
+)
+
+// Mode describes how the adapter receives messages from slack.
+type Mode int
+
+// Modes.
+const (
+	// ModeRTM receives messages through the RTM api's websocket, and
+	// sends them through it as well.
+	ModeRTM Mode = iota
+
+	// ModeEvents receives messages through the Events API, on an endpoint
+	// mounted on the robot's router, and sends them through chat.postMessage.
+	ModeEvents
+
+	// ModeSocket receives events, slash commands and interactions through
+	// a Socket mode websocket, and sends messages through chat.postMessage.
+	ModeSocket
+)
+
+// maxReconnectBackoff caps the time between attempts to reconnect.
+const maxReconnectBackoff = time.Minute
+
+// maxRetryBackoff caps the time between retries of a message.
+const maxRetryBackoff = 30 * time.Second
 
 // Adapter describes a slack adapter.
 type Adapter struct {
+	AppToken         string
+	cacheMu          sync.RWMutex
 	channelsByID     map[string]*marvin.Channel
 	channelsByName   map[string]*marvin.Channel
+	Client           *Client
+	closed           chan struct{}
 	counter          int64
+	eventQueue       chan eventCallback
+	events           map[string]time.Time
+	EventsPath       string
+	imsByUser        map[string]*marvin.Channel
+	Mode             Mode
+	mu               sync.Mutex
+	onState          func(marvin.ConnectionState)
+	queue            chan *message
 	RtmStartEndpoint string
 	self             marvin.User
+	SigningSecret    string
 	token            string
 	usersByID        map[string]*marvin.User
 	usersByName      map[string]*marvin.User
+	writeMu          sync.Mutex
+	writer           sync.WaitGroup
 	ws               *websocket.Conn
+
+	// FailFast makes sending fail with ErrQueueFull when the outgoing
+	// queue is full, rather than wait for room.
+	FailFast bool
+
+	// MaxRetries is how often writing a message is retried before it is
+	// dropped, and RetryBackoff how long to wait before the first retry.
+	// The wait doubles with every retry.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// PingInterval is how often a ping is sent to slack to keep the
+	// connection alive. A connection that receives nothing, not even a
+	// pong, for PingInterval plus PongTimeout is considered dead and
+	// replaced. A zero PingInterval disables the keepalive.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// QueueSize is the number of outgoing messages that can be waiting to
+	// be written. It takes effect when the adapter is opened.
+	QueueSize int
+
+	// ReconnectBackoff is how long to wait before reconnecting after a
+	// failed attempt. The wait doubles with every failed attempt.
+	ReconnectBackoff time.Duration
+
+	// WriteTimeout is how long writing to the websocket may take before
+	// it fails, so that a dead connection cannot block writing forever.
+	WriteTimeout time.Duration
 }
 
 // NewAdapter creates a new slack adapter.
@@ -35,30 +119,184 @@ func NewAdapter(token string) *Adapter {
 	return &Adapter{
 		channelsByID:     map[string]*marvin.Channel{},
 		channelsByName:   map[string]*marvin.Channel{},
+		Client:           NewClient(token),
+		events:           map[string]time.Time{},
+		EventsPath:       DefaultEventsPath,
+		imsByUser:        map[string]*marvin.Channel{},
 		RtmStartEndpoint: "https://slack.com/api/rtm.start?token=%s",
 		token:            token,
 		usersByID:        map[string]*marvin.User{},
 		usersByName:      map[string]*marvin.User{},
+
+		MaxRetries:       DefaultMaxRetries,
+		PingInterval:     DefaultPingInterval,
+		PongTimeout:      DefaultPongTimeout,
+		QueueSize:        DefaultQueueSize,
+		ReconnectBackoff: DefaultReconnectBackoff,
+		RetryBackoff:     DefaultRetryBackoff,
+		WriteTimeout:     DefaultWriteTimeout,
 	}
 }
 
-// sendMessage sends a message to slack's rtm api.
+// sendMessage queues some text to the message's channel. Messages are
+// written in the order they were queued.
 func (a *Adapter) sendMessage(m *marvin.Message, text string) error {
-	a.counter++
+	return a.queueMessage(m.Channel, &message{Text: text})
+}
+
+// queueMessage queues a message to the given channel, formatting its text.
+func (a *Adapter) queueMessage(channel *marvin.Channel, rm *message) error {
+	if channel == nil {
+		return marvin.ErrUnknownChannel
+	}
+
+	a.mu.Lock()
+	queue, closed := a.queue, a.closed
+	a.mu.Unlock()
 
-	rm := &message{
-		ID:      a.counter,
-		Channel: m.Channel.ID,
-		Text:    a.addFormatting(text),
-		Type:    "message",
+	if queue == nil {
+		return ErrNotConnected
 	}
 
-	return a.ws.WriteJSON(rm)
+	rm.ID = atomic.AddInt64(&a.counter, 1)
+	rm.Channel = channel.ID
+	rm.Text = a.addFormatting(rm.Text)
+	rm.Type = "message"
+
+	select {
+	case <-closed:
+		return ErrNotConnected
+	default:
+	}
+
+	if a.FailFast {
+		select {
+		case queue <- rm:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case queue <- rm:
+		return nil
+	case <-closed:
+		return ErrNotConnected
+	}
+}
+
+// writeMessages writes queued messages to the websocket until the adapter
+// is closed, at which point the messages still queued are written once more.
+func (a *Adapter) writeMessages(queue <-chan *message, closed <-chan struct{}) {
+	defer a.writer.Done()
+
+	for {
+		select {
+		case rm := <-queue:
+			a.writeMessage(rm, closed)
+		case <-closed:
+			for {
+				select {
+				case rm := <-queue:
+					if err := a.write(rm); err != nil {
+						log.Printf("slack: dropping message to %s: %s", rm.Channel, err)
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeMessage writes a message to the websocket, retrying with backoff
+// if that fails. Later messages wait, so that their order is kept.
+func (a *Adapter) writeMessage(rm *message, closed <-chan struct{}) {
+	backoff := a.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := a.write(rm)
+		if err == nil {
+			return
+		}
+
+		if attempt >= a.MaxRetries {
+			log.Printf("slack: dropping message to %s after %d attempts: %s", rm.Channel, attempt+1, err)
+			return
+		}
+
+		select {
+		case <-closed:
+			log.Printf("slack: dropping message to %s: %s", rm.Channel, err)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// write sends a message to slack, through the websocket in RTM mode, or
+// through chat.postMessage otherwise.
+func (a *Adapter) write(rm *message) error {
+	if a.Mode != ModeRTM {
+		return a.postMessage(rm)
+	}
+
+	return a.writeJSON(rm)
+}
+
+// writeJSON writes a message to the current websocket connection.
+func (a *Adapter) writeJSON(v interface{}) error {
+	a.mu.Lock()
+	ws := a.ws
+	a.mu.Unlock()
+
+	if ws == nil {
+		return ErrNotConnected
+	}
+
+	return a.writeConn(ws, v)
+}
+
+// writeTo writes a message to the given websocket connection, unless it
+// was replaced in the meantime.
+func (a *Adapter) writeTo(ws *websocket.Conn, v interface{}) error {
+	a.mu.Lock()
+	current := a.ws
+	a.mu.Unlock()
+
+	if current != ws {
+		return ErrNotConnected
+	}
+
+	return a.writeConn(ws, v)
+}
+
+// writeConn writes a message to the websocket connection, giving up after
+// WriteTimeout. Writes hold writeMu rather than mu, so that a write to a
+// dead connection never holds up closing or replacing it.
+func (a *Adapter) writeConn(ws *websocket.Conn, v interface{}) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	if a.WriteTimeout > 0 {
+		ws.SetWriteDeadline(time.Now().Add(a.WriteTimeout))
+	}
+
+	return ws.WriteJSON(v)
 }
 
 // addFormatting escapes & encodes the given
 // text for consumption by slack's rtm api.
 func (a *Adapter) addFormatting(text string) string {
+	a.cacheMu.RLock()
+	defer a.cacheMu.RUnlock()
+
 	text = strings.Replace(text, "&", "&amp;", -1)
 	text = strings.Replace(text, "<", "&lt;", -1)
 	text = strings.Replace(text, ">", "&gt;", -1)
@@ -76,6 +314,10 @@ func (a *Adapter) addFormatting(text string) string {
 			if user, ok := a.usersByName[l]; ok {
 				return fmt.Sprintf("<@%s>", user.ID)
 			}
+
+			if userIDRegexp.MatchString(l) {
+				return fmt.Sprintf("<@%s>", l)
+			}
 		} else if t == "#" {
 			if channel, ok := a.channelsByName[l]; ok {
 				return fmt.Sprintf("<#%s>", channel.ID)
@@ -88,8 +330,21 @@ func (a *Adapter) addFormatting(text string) string {
 	return text
 }
 
+// mention prefixes the text with a mention of the user sending the
+// message, unless it is a direct message or has no user.
+func (a *Adapter) mention(m *marvin.Message, text string) string {
+	if m.Channel != nil && !m.Channel.IsDM && m.User != nil {
+		return "@" + m.User.Name + " " + text
+	}
+
+	return text
+}
+
 // removeFormatting removes all slack-specific formatting.
 func (a *Adapter) removeFormatting(text string) string {
+	a.cacheMu.RLock()
+	defer a.cacheMu.RUnlock()
+
 	text = removeFormattingRegexp.ReplaceAllStringFunc(text, func(m string) string {
 		match := removeFormattingRegexp.FindStringSubmatch(m)
 		t := match[1]
@@ -137,6 +392,25 @@ func (a *Adapter) removeFormatting(text string) string {
 	return text
 }
 
+// cache replaces the users and channels cached in memory with those from
+// the rtm.start response.
+func (a *Adapter) cache(res *rtmStart) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	a.channelsByID = map[string]*marvin.Channel{}
+	a.channelsByName = map[string]*marvin.Channel{}
+	a.imsByUser = map[string]*marvin.Channel{}
+	a.self = res.Self
+	a.usersByID = map[string]*marvin.User{}
+	a.usersByName = map[string]*marvin.User{}
+
+	a.cacheChannels(res.Channels)
+	a.cacheChannels(res.Groups)
+	a.cacheIMs(res.IMs)
+	a.cacheUsers(res.Users)
+}
+
 // cacheChannels takes all the slack channels from
 // the rtm.start response and caches them in memory.
 func (a *Adapter) cacheChannels(channels []marvin.Channel) {
@@ -148,6 +422,20 @@ func (a *Adapter) cacheChannels(channels []marvin.Channel) {
 	}
 }
 
+// cacheIMs takes all the direct message channels from the
+// rtm.start response and caches them in memory.
+func (a *Adapter) cacheIMs(ims []im) {
+	channels := make([]marvin.Channel, 0, len(ims))
+	for _, i := range ims {
+		channels = append(channels, i.Channel)
+	}
+	a.cacheChannels(channels)
+
+	for _, i := range ims {
+		a.imsByUser[i.User] = a.channelsByID[i.ID]
+	}
+}
+
 // cacheUsers takes all the users from the rtm.start
 // response and caches them in memory.
 func (a *Adapter) cacheUsers(users []marvin.User) {
@@ -158,62 +446,135 @@ func (a *Adapter) cacheUsers(users []marvin.User) {
 	}
 }
 
-// Close disconnects the adapter from slack's RTM api.
+// ChannelByName looks up a channel by name.
+func (a *Adapter) ChannelByName(name string) (*marvin.Channel, bool) {
+	a.cacheMu.RLock()
+	defer a.cacheMu.RUnlock()
+
+	channel, ok := a.channelsByName[name]
+	return channel, ok
+}
+
+// Close disconnects the adapter from slack's RTM api, after writing the
+// messages that are still queued.
 func (a *Adapter) Close() error {
+	a.mu.Lock()
+	closed := a.closed
+	a.mu.Unlock()
+
+	if closed == nil {
+		return nil
+	}
+
+	select {
+	case <-closed:
+		return nil
+	default:
+		close(closed)
+	}
+
+	a.writer.Wait()
+
+	a.mu.Lock()
 	if a.ws != nil {
 		a.ws.Close()
 	}
-
 	a.ws = nil
+	a.mu.Unlock()
+
+	a.setState(marvin.Disconnected)
 	return nil
 }
 
-// Open authenticates and connects to slack's RTM api.
-func (a *Adapter) Open(messages chan<- *marvin.Message) error {
-	url := fmt.Sprintf(a.RtmStartEndpoint, a.token)
+// OnConnectionState sets the function that is called whenever the
+// connection to slack is established, lost or being reestablished.
+func (a *Adapter) OnConnectionState(fn func(marvin.ConnectionState)) {
+	a.mu.Lock()
+	a.onState = fn
+	a.mu.Unlock()
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return ErrHTTPStart
+// Open authenticates and connects to slack's RTM api, or its Socket mode
+// api. Whenever the connection is lost after that, the adapter reconnects
+// until it is closed. In Events mode, Open only authenticates; messages are
+// received once the robot serves the endpoint mounted through Mount.
+func (a *Adapter) Open(messages chan<- *marvin.Message) error {
+	if a.Mode == ModeEvents && a.SigningSecret == "" {
+		return ErrNoSigningSecret
 	}
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	if a.Mode == ModeSocket && a.AppToken == "" {
+		return ErrNoAppToken
+	}
 
-	var res rtmStart
-	if err := json.Unmarshal(body, &res); err != nil {
-		return err
+	if a.Mode != ModeRTM {
+		if err := a.authenticate(); err != nil {
+			return err
+		}
 	}
 
-	if !res.Ok {
-		return errors.New(res.Err)
+	var ws *websocket.Conn
+	if a.Mode != ModeEvents {
+		var err error
+		if ws, err = a.connect(); err != nil {
+			return err
+		}
 	}
 
-	a.self = res.Self
-	a.cacheChannels(res.Channels)
-	a.cacheChannels(res.Groups)
-	a.cacheChannels(res.IMs)
-	a.cacheUsers(res.Users)
+	queue := make(chan *message, a.QueueSize)
+	closed := make(chan struct{})
 
-	ws, _, err := websocket.DefaultDialer.Dial(res.URL, nil)
-	if err != nil {
-		return err
+	var eventQueue chan eventCallback
+	if a.Mode == ModeEvents {
+		eventQueue = make(chan eventCallback, a.QueueSize)
 	}
 
+	a.mu.Lock()
+	a.closed = closed
+	a.eventQueue = eventQueue
+	a.queue = queue
 	a.ws = ws
+	a.mu.Unlock()
+
+	a.setState(marvin.Connected)
 
-	go a.receiveMessages(messages)
+	a.writer.Add(1)
+	go a.writeMessages(queue, closed)
+
+	if eventQueue != nil {
+		go a.receiveEvents(eventQueue, closed, messages)
+	}
+
+	if ws != nil {
+		go a.run(ws, closed, messages)
+	}
 
 	return nil
 }
 
 // Reply sends a reply to the user sending the request.
 func (a *Adapter) Reply(m *marvin.Message, text string) error {
-	if !m.Channel.IsDM {
-		text = "@" + m.User.Name + " " + text
+	return a.sendMessage(m, a.mention(m, text))
+}
+
+// ReplyInThread sends a reply to the user sending the request, in the
+// message's thread. If the message was not posted in a thread, one is
+// started from it. With broadcast set, the reply shows up in the channel too.
+func (a *Adapter) ReplyInThread(m *marvin.Message, text string, broadcast bool) error {
+	thread := m.ThreadID
+	if thread == "" {
+		thread = m.ID
 	}
 
-	return a.sendMessage(m, text)
+	if thread == "" {
+		return a.Reply(m, text)
+	}
+
+	return a.queueMessage(m.Channel, &message{
+		ReplyBroadcast: broadcast,
+		Text:           a.mention(m, text),
+		ThreadTS:       thread,
+	})
 }
 
 // Send sends some text back to the channel the message originated from.
@@ -221,42 +582,345 @@ func (a *Adapter) Send(m *marvin.Message, text string) error {
 	return a.sendMessage(m, text)
 }
 
-// SendMessage sends some text to a channel by name.
+// SendDirectMessage sends some text to a user by name, in a direct message.
+func (a *Adapter) SendDirectMessage(user string, text string) error {
+	a.cacheMu.RLock()
+	u, ok := a.usersByName[user]
+	var channel *marvin.Channel
+	if ok {
+		channel = a.imsByUser[u.ID]
+	}
+	a.cacheMu.RUnlock()
+
+	if !ok {
+		return marvin.ErrUnknownUser
+	}
+
+	// Apps can message users directly, without an IM channel.
+	if channel == nil && a.Mode != ModeRTM {
+		channel = &marvin.Channel{ID: u.ID, IsDM: true}
+	}
+
+	if channel == nil {
+		return ErrNoIM
+	}
+
+	return a.sendMessage(&marvin.Message{Channel: channel}, text)
+}
+
+// SendMessage sends some text to a channel by name, or by ID.
 func (a *Adapter) SendMessage(channel string, text string) error {
-	message := marvin.Message{
-		Channel: a.channelsByName[channel],
+	a.cacheMu.RLock()
+	c, ok := a.channelsByName[channel]
+	if !ok {
+		c, ok = a.channelsByID[channel]
+	}
+	a.cacheMu.RUnlock()
+
+	if !ok {
+		return marvin.ErrUnknownChannel
 	}
-	return a.sendMessage(&message, text)
+
+	return a.sendMessage(&marvin.Message{Channel: c}, text)
 }
 
-// receiveMessages receives messages from the websocket
-func (a *Adapter) receiveMessages(messages chan<- *marvin.Message) {
+// UserByName looks up a user by name.
+func (a *Adapter) UserByName(name string) (*marvin.User, bool) {
+	a.cacheMu.RLock()
+	defer a.cacheMu.RUnlock()
+
+	user, ok := a.usersByName[name]
+	return user, ok
+}
+
+// connect dials the websocket slack hands out through rtm.start, or
+// through apps.connections.open in Socket mode.
+func (a *Adapter) connect() (*websocket.Conn, error) {
+	var url string
+	var err error
+	if a.Mode == ModeSocket {
+		url, err = a.openConnection()
+	} else {
+		url, err = a.startRTM()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// keepAlive pings slack through the websocket every PingInterval, until
+// done is closed or the websocket is replaced. Socket mode connections are
+// pinged with control frames; the RTM api expects ping messages instead.
+func (a *Adapter) keepAlive(ws *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(a.PingInterval)
+	defer ticker.Stop()
+
 	for {
-		_, body, err := a.ws.ReadMessage()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		if a.Mode == ModeSocket {
+			err = ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(a.PongTimeout))
+		} else {
+			err = a.writeTo(ws, &ping{ID: atomic.AddInt64(&a.counter, 1), Type: "ping"})
+		}
+
 		if err != nil {
-			fmt.Printf("Error receiving message %+v\n", err)
-			continue
+			return
 		}
+	}
+}
 
-		m := message{}
-		if err := json.Unmarshal(body, &m); err != nil {
-			fmt.Printf("Error unmarshaling message: %s\n", err)
-			continue
+// receiveMessages receives messages from the websocket until the
+// connection is lost, slack says goodbye or the adapter is closed.
+func (a *Adapter) receiveMessages(ws *websocket.Conn, closed <-chan struct{}, messages chan<- *marvin.Message) {
+	if a.PingInterval > 0 {
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(a.PingInterval + a.PongTimeout))
+		})
+
+		done := make(chan struct{})
+		defer close(done)
+		go a.keepAlive(ws, done)
+	}
+
+	handle := a.handleRTM
+	if a.Mode == ModeSocket {
+		handle = a.handleEnvelope
+	}
+
+	for {
+		if a.PingInterval > 0 {
+			ws.SetReadDeadline(time.Now().Add(a.PingInterval + a.PongTimeout))
 		}
 
-		if m.Type != "message" || m.User == a.self.ID {
-			continue
+		_, body, err := ws.ReadMessage()
+		if err != nil {
+			// A websocket cannot be read from again after an error.
+			select {
+			case <-closed:
+			default:
+				log.Printf("slack: error receiving message: %s", err)
+			}
+			return
 		}
 
-		channel := a.channelsByID[m.Channel]
-		if channel.IsDM {
-			m.Text = a.self.Name + " " + m.Text
+		if !handle(ws, body, closed, messages) {
+			return
 		}
+	}
+}
+
+// handleRTM handles a message from the RTM api. It returns false when
+// slack says goodbye or the adapter is closed.
+func (a *Adapter) handleRTM(ws *websocket.Conn, body []byte, closed <-chan struct{}, messages chan<- *marvin.Message) bool {
+	if a.updateCache(body) {
+		return true
+	}
+
+	m := message{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		log.Printf("slack: error unmarshaling message: %s", err)
+		return true
+	}
+
+	if m.Type == "goodbye" {
+		return false
+	}
+
+	a.cacheMu.RLock()
+	self := a.self
+	a.cacheMu.RUnlock()
+
+	if m.Type != "message" || m.User == self.ID {
+		return true
+	}
+
+	channel := a.lookupChannel(m.Channel, "")
+	user := a.lookupUser(m.User, "")
+
+	if channel != nil && channel.IsDM {
+		m.Text = self.Name + " " + m.Text
+	}
+
+	received := &marvin.Message{
+		Channel:  channel,
+		User:     user,
+		Text:     a.removeFormatting(m.Text),
+		ID:       m.TS,
+		ThreadID: threadID(m.TS, m.ThreadTS),
+	}
+
+	select {
+	case messages <- received:
+		return true
+	case <-closed:
+		return false
+	}
+}
 
-		messages <- &marvin.Message{
-			Channel: channel,
-			User:    a.usersByID[m.User],
-			Text:    a.removeFormatting(m.Text),
+// reconnect connects to slack again, waiting longer after every failed
+// attempt, until it succeeds or the adapter is closed.
+func (a *Adapter) reconnect(closed <-chan struct{}) *websocket.Conn {
+	backoff := a.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	for {
+		a.setState(marvin.Reconnecting)
+
+		ws, err := a.connect()
+		if err == nil {
+			a.mu.Lock()
+			select {
+			case <-closed:
+				a.mu.Unlock()
+				ws.Close()
+				return nil
+			default:
+			}
+			a.ws = ws
+			a.mu.Unlock()
+
+			a.setState(marvin.Connected)
+			return ws
+		}
+
+		log.Printf("slack: error reconnecting, retrying in %s: %s", backoff, err)
+
+		select {
+		case <-closed:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// replace connects to slack again and swaps the new connection in for ws,
+// which is left for run to close. If connecting fails, ws stays in place
+// until it is lost and run reconnects as usual.
+func (a *Adapter) replace(ws *websocket.Conn, closed <-chan struct{}) {
+	next, err := a.connect()
+	if err != nil {
+		log.Printf("slack: error replacing connection: %s", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	select {
+	case <-closed:
+		next.Close()
+		return
+	default:
+	}
+
+	if a.ws != ws {
+		next.Close()
+		return
+	}
+
+	a.ws = next
+}
+
+// run receives messages until the adapter is closed, replacing the
+// connection whenever it is lost. A connection that was already replaced
+// is closed and receiving continues on its replacement.
+func (a *Adapter) run(ws *websocket.Conn, closed <-chan struct{}, messages chan<- *marvin.Message) {
+	for {
+		a.receiveMessages(ws, closed, messages)
+
+		a.mu.Lock()
+		next := a.ws
+		if next == ws {
+			a.ws = nil
+			next = nil
+		}
+		a.mu.Unlock()
+		ws.Close()
+
+		select {
+		case <-closed:
+			return
+		default:
+		}
+
+		if next != nil {
+			ws = next
+			continue
+		}
+
+		a.setState(marvin.Disconnected)
+
+		if ws = a.reconnect(closed); ws == nil {
+			return
 		}
 	}
 }
+
+// setState reports a change in the state of the connection.
+func (a *Adapter) setState(state marvin.ConnectionState) {
+	a.mu.Lock()
+	fn := a.onState
+	a.mu.Unlock()
+
+	if fn != nil {
+		fn(state)
+	}
+}
+
+// startRTM calls rtm.start, refreshes the cache with its response
+// and returns the URL of the websocket it points to.
+func (a *Adapter) startRTM() (string, error) {
+	url := fmt.Sprintf(a.RtmStartEndpoint, a.token)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", ErrHTTPStart
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var res rtmStart
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", err
+	}
+
+	if !res.Ok {
+		return "", errors.New(res.Err)
+	}
+
+	a.cache(&res)
+	return res.URL, nil
+}
+
+// threadID returns the ID of the thread a message with the given timestamp
+// was posted in, given its thread_ts. The message starting a thread has the
+// same timestamp as the thread, but was not posted in it.
+func threadID(ts string, threadTS string) string {
+	if threadTS == ts {
+		return ""
+	}
+
+	return threadTS
+}
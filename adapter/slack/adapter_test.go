@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -269,3 +271,476 @@ func TestSend(t *testing.T) {
 
 	time.Sleep(time.Millisecond)
 }
+
+func TestSendQueue(t *testing.T) {
+	var URL *url.URL
+
+	m := &marvin.Message{
+		Channel: &marvin.Channel{ID: "1234", Name: "general"},
+	}
+
+	received := make(chan string, 20)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rtm.start" {
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\"}"))
+		}
+
+		if r.URL.Path == "/rtm" {
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			for {
+				var rm struct {
+					Text string `json:"text"`
+				}
+				if err := conn.ReadJSON(&rm); err != nil {
+					return
+				}
+				received <- rm.Text
+			}
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+
+	if err := adapter.Send(m, "too early"); err != slack.ErrNotConnected {
+		t.Errorf("Send should have returned ErrNotConnected before opening, got %v", err)
+	}
+
+	messages := make(chan *marvin.Message)
+	adapter.Open(messages)
+
+	for i := 0; i < 10; i++ {
+		if err := adapter.Send(m, fmt.Sprint(i)); err != nil {
+			t.Errorf("Send should not have returned an error, got %s", err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case text := <-received:
+			if text != fmt.Sprint(i) {
+				t.Errorf("Expected message %d, got %s", i, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for messages")
+		}
+	}
+
+	adapter.Close()
+
+	if err := adapter.Send(m, "too late"); err != slack.ErrNotConnected {
+		t.Errorf("Send should have returned ErrNotConnected after closing, got %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var URL *url.URL
+
+	var starts int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rtm.start" {
+			channel := "general"
+			if atomic.AddInt32(&starts, 1) > 1 {
+				channel = "random"
+			}
+
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\",\"channels\":[{\"id\":\"C1\",\"name\":\"" + channel + "\"}],\"users\":[{\"id\":\"U1\",\"name\":\"someperson\"}]}"))
+		}
+
+		if r.URL.Path == "/rtm" {
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			if atomic.LoadInt32(&starts) == 1 {
+				conn.WriteJSON(map[string]string{"type": "goodbye"})
+				conn.ReadMessage()
+				return
+			}
+
+			conn.WriteJSON(map[string]string{"type": "message", "channel": "C1", "user": "U1", "text": "hello"})
+			conn.ReadMessage()
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+
+	states := make(chan marvin.ConnectionState, 10)
+	adapter.OnConnectionState(func(state marvin.ConnectionState) {
+		states <- state
+	})
+
+	messages := make(chan *marvin.Message)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	select {
+	case m := <-messages:
+		if m.Text != "hello" || m.Channel.Name != "random" || m.User.Name != "someperson" {
+			t.Errorf("Received the wrong message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message after reconnecting")
+	}
+
+	if _, ok := adapter.ChannelByName("random"); !ok {
+		t.Error("The cache should have been refreshed when reconnecting")
+	}
+
+	expected := []marvin.ConnectionState{marvin.Connected, marvin.Disconnected, marvin.Reconnecting, marvin.Connected}
+	for _, state := range expected {
+		if got := <-states; got != state {
+			t.Errorf("Expected state %s, got %s", state, got)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	var URL *url.URL
+
+	done := make(chan struct{})
+	pings := make(chan string, 10)
+	starts := make(chan struct{}, 10)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rtm.start" {
+			starts <- struct{}{}
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\"}"))
+		}
+
+		if r.URL.Path == "/rtm" {
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			// Read pings, but never answer them.
+			go func() {
+				for {
+					var p struct {
+						Type string `json:"type"`
+					}
+					if err := conn.ReadJSON(&p); err != nil {
+						return
+					}
+					pings <- p.Type
+				}
+			}()
+
+			<-done
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	defer close(done)
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.PingInterval = 20 * time.Millisecond
+	adapter.PongTimeout = 20 * time.Millisecond
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+
+	messages := make(chan *marvin.Message)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	select {
+	case p := <-pings:
+		if p != "ping" {
+			t.Errorf("Expected a ping, got %s", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a ping")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-starts:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the dead connection to be replaced")
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	var URL *url.URL
+
+	done := make(chan struct{})
+	starts := make(chan struct{}, 10)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rtm.start" {
+			starts <- struct{}{}
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\",\"channels\":[{\"id\":\"C1\",\"name\":\"general\"}]}"))
+		}
+
+		if r.URL.Path == "/rtm" {
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			// Never read, so that writes block once the buffers are full.
+			<-done
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	defer close(done)
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.MaxRetries = 0
+	adapter.PingInterval = 20 * time.Millisecond
+	adapter.PongTimeout = 20 * time.Millisecond
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+	adapter.WriteTimeout = 50 * time.Millisecond
+
+	messages := make(chan *marvin.Message)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	text := strings.Repeat("x", 1<<19)
+	for i := 0; i < 12; i++ {
+		adapter.SendMessage("general", text)
+	}
+
+	// The dead connection is replaced while writes to it are blocked.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-starts:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the dead connection to be replaced")
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		adapter.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the adapter to close")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	var URL *url.URL
+	var lookups int32
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rtm.start":
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\",\"self\":{\"id\":\"UBOT\",\"name\":\"marvin\"},\"channels\":[{\"id\":\"C1\",\"name\":\"general\"}],\"users\":[{\"id\":\"U1\",\"name\":\"someperson\"}]}"))
+		case "/conversations.info":
+			w.Write([]byte(`{"ok":true,"channel":{"id":"C9","name":"secret"}}`))
+		case "/users.info":
+			// The first lookup is rate limited, which is neither retried nor cached.
+			if atomic.AddInt32(&lookups, 1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"ok":true,"user":{"id":"U9","name":"stranger"}}`))
+		case "/rtm":
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			events := []string{
+				`{"type":"channel_rename","channel":{"id":"C1","name":"lobby"}}`,
+				`{"type":"channel_created","channel":{"id":"C2","name":"launches"}}`,
+				`{"type":"team_join","user":{"id":"U2","name":"newhire"}}`,
+				`{"type":"user_change","user":{"id":"U1","name":"renamed"}}`,
+				`{"type":"im_created","user":"U2","channel":{"id":"D2"}}`,
+				`{"type":"message","channel":"C2","user":"U2","text":"hello"}`,
+				`{"type":"message","channel":"C9","user":"U9","text":"psst"}`,
+				`{"type":"message","channel":"C9","user":"U9","text":"psst!"}`,
+				`{"type":"message","channel":"D2","user":"U2","text":"help"}`,
+			}
+			for _, e := range events {
+				conn.WriteMessage(websocket.TextMessage, []byte(e))
+			}
+			conn.ReadMessage()
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.Client.BaseURL = URL.String() + "/"
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+
+	messages := make(chan *marvin.Message)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	expected := []struct {
+		channel string
+		text    string
+		user    string
+	}{
+		{channel: "launches", text: "hello", user: "newhire"},
+		{channel: "secret", text: "psst", user: "U9"},
+		{channel: "secret", text: "psst!", user: "stranger"},
+		{channel: "", text: "marvin help", user: "newhire"},
+	}
+	for i, e := range expected {
+		select {
+		case m := <-messages:
+			if m.Channel.Name != e.channel || m.Text != e.text || m.User.Name != e.user {
+				t.Errorf("%d: expected %q from %s in %q, got %q from %s in %q", i, e.text, e.user, e.channel, m.Text, m.User.Name, m.Channel.Name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: timed out waiting for %q", i, e.text)
+		}
+	}
+
+	for _, name := range []string{"lobby", "launches", "secret"} {
+		if _, ok := adapter.ChannelByName(name); !ok {
+			t.Errorf("Channel %s should have been cached", name)
+		}
+	}
+
+	if _, ok := adapter.ChannelByName("general"); ok {
+		t.Error("Channel general should have been renamed")
+	}
+
+	for _, name := range []string{"newhire", "renamed", "stranger"} {
+		if _, ok := adapter.UserByName(name); !ok {
+			t.Errorf("User %s should have been cached", name)
+		}
+	}
+
+	if _, ok := adapter.UserByName("someperson"); ok {
+		t.Error("User someperson should have been renamed")
+	}
+}
+
+func TestThreads(t *testing.T) {
+	var URL *url.URL
+
+	written := make(chan map[string]interface{}, 10)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rtm.start" {
+			URL.Scheme = "ws"
+			w.Write([]byte("{\"ok\":true,\"url\":\"" + URL.String() + "/rtm\",\"channels\":[{\"id\":\"C1\",\"name\":\"general\"}],\"users\":[{\"id\":\"U1\",\"name\":\"someperson\"}]}"))
+		}
+
+		if r.URL.Path == "/rtm" {
+			upgrader := websocket.Upgrader{
+				ReadBufferSize:  1024,
+				WriteBufferSize: 1024,
+			}
+
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			defer conn.Close()
+
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","channel":"C1","user":"U1","text":"top","ts":"100.000001"}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","channel":"C1","user":"U1","text":"reply","ts":"100.000002","thread_ts":"100.000001"}`))
+
+			for {
+				var rm map[string]interface{}
+				if err := conn.ReadJSON(&rm); err != nil {
+					return
+				}
+				written <- rm
+			}
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(h))
+	defer ts.Close()
+	URL, _ = url.Parse(ts.URL)
+
+	adapter := slack.NewAdapter(testToken)
+	adapter.RtmStartEndpoint = URL.String() + "/rtm.start?token=%s"
+
+	messages := make(chan *marvin.Message)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	top, reply := <-messages, <-messages
+	if top.ID != "100.000001" || top.ThreadID != "" {
+		t.Errorf("Expected a message outside of a thread, got ID %q and thread %q", top.ID, top.ThreadID)
+	}
+
+	if reply.ID != "100.000002" || reply.ThreadID != "100.000001" {
+		t.Errorf("Expected a message in a thread, got ID %q and thread %q", reply.ID, reply.ThreadID)
+	}
+
+	adapter.ReplyInThread(top, "started", false)
+	adapter.ReplyInThread(reply, "continued", true)
+	adapter.Reply(top, "outside")
+
+	expected := []struct {
+		broadcast bool
+		text      string
+		thread    string
+	}{
+		{text: "<@U1> started", thread: "100.000001"},
+		{broadcast: true, text: "<@U1> continued", thread: "100.000001"},
+		{text: "<@U1> outside"},
+	}
+	for i, e := range expected {
+		select {
+		case rm := <-written:
+			thread, _ := rm["thread_ts"].(string)
+			broadcast, _ := rm["reply_broadcast"].(bool)
+			if rm["text"] != e.text || thread != e.thread || broadcast != e.broadcast {
+				t.Errorf("%d: expected %q in thread %q (broadcast %t), got %v", i, e.text, e.thread, e.broadcast, rm)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: timed out waiting for %q", i, e.text)
+		}
+	}
+}
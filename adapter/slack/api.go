@@ -0,0 +1,446 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default Web API settings.
+const (
+	DefaultAPIURL     = "https://slack.com/api/"
+	DefaultAPIRetries = 3
+	DefaultAPITimeout = 10 * time.Second
+	DefaultPageSize   = 200
+)
+
+// defaultRetryAfter is how long to wait before retrying a rate limited
+// call when slack does not say how long.
+const defaultRetryAfter = time.Second
+
+// Client describes a client for slack's Web API.
+type Client struct {
+	token string
+
+	// BaseURL is the URL the names of Web API methods are appended to.
+	BaseURL string
+
+	// HTTPClient is the client requests are made with. It defaults to a
+	// client that gives up on calls after DefaultAPITimeout.
+	HTTPClient *http.Client
+
+	// MaxRetries is how often a call that is rate limited is retried, after
+	// waiting as long as slack asks, before it fails with a RateLimitedError.
+	MaxRetries int
+
+	// PageSize is the number of items requested per page from paginated methods.
+	PageSize int
+}
+
+// NewClient creates a new Web API client.
+func NewClient(token string) *Client {
+	return &Client{
+		token: token,
+
+		BaseURL:    DefaultAPIURL,
+		HTTPClient: &http.Client{Timeout: DefaultAPITimeout},
+		MaxRetries: DefaultAPIRetries,
+		PageSize:   DefaultPageSize,
+	}
+}
+
+// Conversation describes a channel, private channel or direct message
+// channel as it comes from the Web API.
+type Conversation struct {
+	ID        string `json:"id"`
+	IsChannel bool   `json:"is_channel"`
+	IsGroup   bool   `json:"is_group"`
+	IsIM      bool   `json:"is_im"`
+	IsMember  bool   `json:"is_member"`
+	IsPrivate bool   `json:"is_private"`
+	Name      string `json:"name"`
+	User      string `json:"user"`
+}
+
+// File describes an uploaded file.
+type File struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Permalink string `json:"permalink"`
+	Title     string `json:"title"`
+}
+
+// FileUpload describes a file to upload, and where to share it.
+type FileUpload struct {
+	Channels       []string
+	Content        io.Reader
+	Filename       string
+	InitialComment string
+	ThreadTS       string
+	Title          string
+}
+
+// OutgoingMessage describes a message to post or update. Blocks, if set,
+// is the message's layout as a JSON array of blocks, in which case Text is
+// used in notifications. ReplyBroadcast makes a reply in the thread with
+// timestamp ThreadTS show up in the channel as well.
+type OutgoingMessage struct {
+	Blocks         json.RawMessage
+	Channel        string
+	ReplyBroadcast bool
+	Text           string
+	ThreadTS       string
+}
+
+// RateLimitedError describes a call that was still rate limited after
+// being retried.
+type RateLimitedError struct {
+	// RetryAfter is how long slack asked to wait before calling again.
+	RetryAfter time.Duration
+}
+
+// Error returns the error
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limited by slack, retry after %s", e.RetryAfter)
+}
+
+// User describes a user as it comes from the Web API.
+type User struct {
+	Deleted  bool   `json:"deleted"`
+	ID       string `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	TZ       string `json:"tz"`
+
+	Profile struct {
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	} `json:"profile"`
+}
+
+// apiResponse describes the fields every Web API response has
+type apiResponse struct {
+	Err string `json:"error"`
+	Ok  bool   `json:"ok"`
+
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// appsConnectionsOpen describes the structure of the apps.connections.open response
+type appsConnectionsOpen struct {
+	URL string `json:"url"`
+}
+
+// authTest describes the structure of the auth.test response
+type authTest struct {
+	User   string `json:"user"`
+	UserID string `json:"user_id"`
+}
+
+// values returns the message as form values.
+func (m *OutgoingMessage) values() url.Values {
+	v := url.Values{"channel": {m.Channel}}
+
+	if len(m.Blocks) > 0 {
+		v.Set("blocks", string(m.Blocks))
+	}
+
+	if m.ReplyBroadcast {
+		v.Set("reply_broadcast", "true")
+	}
+
+	if m.Text != "" {
+		v.Set("text", m.Text)
+	}
+
+	if m.ThreadTS != "" {
+		v.Set("thread_ts", m.ThreadTS)
+	}
+
+	return v
+}
+
+// call calls a Web API method, posting the values as a form, and decodes
+// the response into v unless it is nil.
+func (c *Client) call(method string, values url.Values, v interface{}) error {
+	body := values.Encode()
+
+	return c.do(method, func() (io.Reader, string) {
+		return strings.NewReader(body), "application/x-www-form-urlencoded"
+	}, v)
+}
+
+// do calls a Web API method with the body returned by the given function,
+// which is called again for every retry. Calls that are rate limited are
+// retried after waiting as long as slack asks.
+func (c *Client) do(method string, body func() (io.Reader, string), v interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		r, contentType := body()
+
+		req, err := http.NewRequest("POST", c.BaseURL+method, r)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			wait := retryAfter(resp.Header)
+			if attempt >= c.MaxRetries {
+				return &RateLimitedError{RetryAfter: wait}
+			}
+
+			time.Sleep(wait)
+			continue
+		}
+
+		var res apiResponse
+		if err := json.Unmarshal(data, &res); err != nil {
+			return err
+		}
+
+		if !res.Ok {
+			return Error(res.Err)
+		}
+
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, v)
+	}
+}
+
+// paginate calls a paginated Web API method until it has no more pages,
+// calling fn with every page's response.
+func (c *Client) paginate(method string, values url.Values, fn func([]byte) error) error {
+	values.Set("limit", strconv.Itoa(c.PageSize))
+
+	for {
+		var page json.RawMessage
+		if err := c.call(method, values, &page); err != nil {
+			return err
+		}
+
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		var res apiResponse
+		if err := json.Unmarshal(page, &res); err != nil {
+			return err
+		}
+
+		if res.ResponseMetadata.NextCursor == "" {
+			return nil
+		}
+
+		values.Set("cursor", res.ResponseMetadata.NextCursor)
+	}
+}
+
+// withoutRetries returns a copy of the client that fails calls that are
+// rate limited straight away, rather than waiting to retry them.
+func (c *Client) withoutRetries() *Client {
+	clone := *c
+	clone.MaxRetries = 0
+	return &clone
+}
+
+// withToken returns a copy of the client that calls methods with the given token.
+func (c *Client) withToken(token string) *Client {
+	clone := *c
+	clone.token = token
+	return &clone
+}
+
+// retryAfter returns how long the Retry-After header asks to wait.
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// AddReaction adds an emoji reaction, by name, to a message.
+func (c *Client) AddReaction(channel string, ts string, name string) error {
+	return c.call("reactions.add", url.Values{"channel": {channel}, "name": {name}, "timestamp": {ts}}, nil)
+}
+
+// ConversationInfo looks up a conversation by ID.
+func (c *Client) ConversationInfo(id string) (*Conversation, error) {
+	var res struct {
+		Channel Conversation `json:"channel"`
+	}
+	if err := c.call("conversations.info", url.Values{"channel": {id}}, &res); err != nil {
+		return nil, err
+	}
+
+	return &res.Channel, nil
+}
+
+// ConversationMembers returns the IDs of the members of a conversation.
+func (c *Client) ConversationMembers(id string) ([]string, error) {
+	members := []string{}
+	err := c.paginate("conversations.members", url.Values{"channel": {id}}, func(page []byte) error {
+		var res struct {
+			Members []string `json:"members"`
+		}
+		if err := json.Unmarshal(page, &res); err != nil {
+			return err
+		}
+
+		members = append(members, res.Members...)
+		return nil
+	})
+
+	return members, err
+}
+
+// Conversations lists the conversations of the given types, such as
+// `public_channel,private_channel,im`. Archived conversations are left out.
+func (c *Client) Conversations(types string) ([]Conversation, error) {
+	values := url.Values{"exclude_archived": {"true"}}
+	if types != "" {
+		values.Set("types", types)
+	}
+
+	conversations := []Conversation{}
+	err := c.paginate("conversations.list", values, func(page []byte) error {
+		var res struct {
+			Channels []Conversation `json:"channels"`
+		}
+		if err := json.Unmarshal(page, &res); err != nil {
+			return err
+		}
+
+		conversations = append(conversations, res.Channels...)
+		return nil
+	})
+
+	return conversations, err
+}
+
+// DeleteMessage deletes the message with the given timestamp.
+func (c *Client) DeleteMessage(channel string, ts string) error {
+	return c.call("chat.delete", url.Values{"channel": {channel}, "ts": {ts}}, nil)
+}
+
+// PostMessage posts a message and returns its timestamp, which identifies it.
+func (c *Client) PostMessage(m *OutgoingMessage) (string, error) {
+	var res struct {
+		TS string `json:"ts"`
+	}
+	if err := c.call("chat.postMessage", m.values(), &res); err != nil {
+		return "", err
+	}
+
+	return res.TS, nil
+}
+
+// UpdateMessage replaces the message with the given timestamp.
+func (c *Client) UpdateMessage(ts string, m *OutgoingMessage) error {
+	values := m.values()
+	values.Set("ts", ts)
+	return c.call("chat.update", values, nil)
+}
+
+// UploadFile uploads a file and shares it in the given channels.
+func (c *Client) UploadFile(upload *FileUpload) (*File, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	fields := map[string]string{
+		"channels":        strings.Join(upload.Channels, ","),
+		"filename":        upload.Filename,
+		"initial_comment": upload.InitialComment,
+		"thread_ts":       upload.ThreadTS,
+		"title":           upload.Title,
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+
+		if err := w.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := w.CreateFormFile("file", upload.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(part, upload.Content); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		File File `json:"file"`
+	}
+	err = c.do("files.upload", func() (io.Reader, string) {
+		return bytes.NewReader(buf.Bytes()), w.FormDataContentType()
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res.File, nil
+}
+
+// UserInfo looks up a user by ID.
+func (c *Client) UserInfo(id string) (*User, error) {
+	var res struct {
+		User User `json:"user"`
+	}
+	if err := c.call("users.info", url.Values{"user": {id}}, &res); err != nil {
+		return nil, err
+	}
+
+	return &res.User, nil
+}
+
+// postMessage sends a message through chat.postMessage.
+func (a *Adapter) postMessage(rm *message) error {
+	_, err := a.Client.PostMessage(&OutgoingMessage{
+		Channel:        rm.Channel,
+		ReplyBroadcast: rm.ReplyBroadcast,
+		Text:           rm.Text,
+		ThreadTS:       rm.ThreadTS,
+	})
+	return err
+}
@@ -0,0 +1,157 @@
+package slack_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chielkunkels/marvin/adapter/slack"
+)
+
+func TestClientPagination(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/conversations.list" || r.FormValue("types") != "public_channel,im" || r.FormValue("limit") != "2" {
+			t.Errorf("Unexpected call to %s with %v", r.URL.Path, r.Form)
+		}
+
+		switch r.FormValue("cursor") {
+		case "":
+			w.Write([]byte(`{"ok":true,"channels":[{"id":"C1","name":"general"},{"id":"C2","name":"random"}],"response_metadata":{"next_cursor":"page2"}}`))
+		case "page2":
+			w.Write([]byte(`{"ok":true,"channels":[{"id":"D1","is_im":true,"user":"U1"}],"response_metadata":{"next_cursor":""}}`))
+		}
+	}))
+	defer ts.Close()
+
+	client := slack.NewClient(testToken)
+	client.BaseURL = ts.URL + "/"
+	client.PageSize = 2
+
+	conversations, err := client.Conversations("public_channel,im")
+	if err != nil {
+		t.Fatalf("Conversations should not have returned an error, got %s", err)
+	}
+
+	if len(conversations) != 3 || conversations[1].Name != "random" || !conversations[2].IsIM || conversations[2].User != "U1" {
+		t.Errorf("Expected the conversations from both pages, got %+v", conversations)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error":"ratelimited"}`))
+			return
+		}
+
+		w.Write([]byte(`{"ok":true,"user":{"id":"U1","name":"someperson","real_name":"Some Person"}}`))
+	}))
+	defer ts.Close()
+
+	client := slack.NewClient(testToken)
+	client.BaseURL = ts.URL + "/"
+
+	user, err := client.UserInfo("U1")
+	if err != nil {
+		t.Fatalf("UserInfo should have been retried, got %s", err)
+	}
+
+	if user.Name != "someperson" || user.RealName != "Some Person" {
+		t.Errorf("Got the wrong user: %+v", user)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	client.MaxRetries = 0
+
+	_, err = client.UserInfo("U1")
+	if err, ok := err.(*slack.RateLimitedError); !ok || err.RetryAfter != 0 {
+		t.Errorf("UserInfo should have returned a RateLimitedError, got %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+	}))
+	defer ts.Close()
+
+	client := slack.NewClient(testToken)
+	client.BaseURL = ts.URL + "/"
+
+	if _, err := client.PostMessage(&slack.OutgoingMessage{Channel: "C404", Text: "hi"}); err != slack.Error("channel_not_found") {
+		t.Errorf("PostMessage should have returned channel_not_found, got %v", err)
+	}
+}
+
+func TestClientMessages(t *testing.T) {
+	calls := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			t.Errorf("Expected the token to be sent, got %q", r.Header.Get("Authorization"))
+		}
+
+		r.ParseForm()
+		calls <- r.URL.Path + " " + r.Form.Encode()
+		w.Write([]byte(`{"ok":true,"ts":"1503435956.000247"}`))
+	}))
+	defer ts.Close()
+
+	client := slack.NewClient(testToken)
+	client.BaseURL = ts.URL + "/"
+
+	ts1, err := client.PostMessage(&slack.OutgoingMessage{Blocks: []byte(`[{"type":"divider"}]`), Channel: "C1", Text: "hi"})
+	if err != nil || ts1 != "1503435956.000247" {
+		t.Errorf("PostMessage should have returned the message's timestamp, got %q, %v", ts1, err)
+	}
+	client.UpdateMessage(ts1, &slack.OutgoingMessage{Channel: "C1", Text: "hello"})
+	client.AddReaction("C1", ts1, "thumbsup")
+	client.DeleteMessage("C1", ts1)
+
+	expected := []string{
+		"/chat.postMessage blocks=%5B%7B%22type%22%3A%22divider%22%7D%5D&channel=C1&text=hi",
+		"/chat.update channel=C1&text=hello&ts=1503435956.000247",
+		"/reactions.add channel=C1&name=thumbsup&timestamp=1503435956.000247",
+		"/chat.delete channel=C1&ts=1503435956.000247",
+	}
+	for _, call := range expected {
+		if got := <-calls; got != call {
+			t.Errorf("Expected call %q, got %q", call, got)
+		}
+	}
+}
+
+func TestClientUploadFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Expected a file to be uploaded, got %s", err)
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+
+		if string(content) != "uptime 42d" || header.Filename != "status.txt" || r.FormValue("channels") != "C1,C2" {
+			t.Errorf("Uploaded the wrong file: %q %q %q", content, header.Filename, r.FormValue("channels"))
+		}
+
+		w.Write([]byte(`{"ok":true,"file":{"id":"F1","name":"status.txt"}}`))
+	}))
+	defer ts.Close()
+
+	client := slack.NewClient(testToken)
+	client.BaseURL = ts.URL + "/"
+
+	file, err := client.UploadFile(&slack.FileUpload{
+		Channels: []string{"C1", "C2"},
+		Content:  strings.NewReader("uptime 42d"),
+		Filename: "status.txt",
+	})
+	if err != nil || file.ID != "F1" {
+		t.Errorf("UploadFile should have returned the file, got %+v, %v", file, err)
+	}
+}
@@ -0,0 +1,190 @@
+package slack
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// cacheEvent describes an event that changes a user or a channel
+type cacheEvent struct {
+	Channel json.RawMessage `json:"channel"`
+	Type    string          `json:"type"`
+	User    json.RawMessage `json:"user"`
+}
+
+// deleteChannel removes a channel from the cache.
+func (a *Adapter) deleteChannel(id string) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	channel, ok := a.channelsByID[id]
+	if !ok {
+		return
+	}
+
+	delete(a.channelsByID, id)
+	if a.channelsByName[channel.Name] == channel {
+		delete(a.channelsByName, channel.Name)
+	}
+
+	for user, im := range a.imsByUser {
+		if im == channel {
+			delete(a.imsByUser, user)
+		}
+	}
+}
+
+// lookupChannel returns the channel with the given ID from the cache. If
+// it is not cached, it is looked up through the Web API and cached. As
+// lookups happen while receiving messages, rate limited lookups are not
+// retried; a failed lookup returns the channel as named, without caching
+// it, so that it is looked up again next time.
+func (a *Adapter) lookupChannel(id string, name string) *marvin.Channel {
+	if id == "" {
+		return nil
+	}
+
+	a.cacheMu.RLock()
+	channel := a.channelsByID[id]
+	a.cacheMu.RUnlock()
+
+	if channel != nil {
+		return channel
+	}
+
+	info, err := a.Client.withoutRetries().ConversationInfo(id)
+	if err != nil {
+		log.Printf("slack: error looking up channel %s: %s", id, err)
+		return &marvin.Channel{ID: id, IsDM: strings.HasPrefix(id, "D"), Name: name}
+	}
+
+	return a.storeChannel(marvin.Channel{ID: id, IsDM: info.IsIM, Name: info.Name}, info.User)
+}
+
+// lookupUser returns the user with the given ID from the cache. If they
+// are not cached, they are looked up through the Web API and cached, in
+// the same way as lookupChannel does.
+func (a *Adapter) lookupUser(id string, name string) *marvin.User {
+	if id == "" {
+		return nil
+	}
+
+	a.cacheMu.RLock()
+	user := a.usersByID[id]
+	a.cacheMu.RUnlock()
+
+	if user != nil {
+		return user
+	}
+
+	if name == "" {
+		name = id
+	}
+
+	info, err := a.Client.withoutRetries().UserInfo(id)
+	if err != nil {
+		log.Printf("slack: error looking up user %s: %s", id, err)
+		return &marvin.User{ID: id, Name: name}
+	}
+
+	return a.storeUser(marvin.User{ID: id, Name: info.Name})
+}
+
+// storeChannel adds a channel to the cache, or replaces it, and returns
+// the cached channel. If user is set, the channel is their IM channel.
+func (a *Adapter) storeChannel(c marvin.Channel, user string) *marvin.Channel {
+	c.IsDM = c.IsDM || user != "" || strings.HasPrefix(c.ID, "D")
+
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	if old, ok := a.channelsByID[c.ID]; ok {
+		if old.Name != c.Name && a.channelsByName[old.Name] == old {
+			delete(a.channelsByName, old.Name)
+		}
+
+		// Events renaming a channel only carry its ID and name.
+		c.IsDM = c.IsDM || old.IsDM
+		if user == "" {
+			for u, im := range a.imsByUser {
+				if im == old {
+					user = u
+				}
+			}
+		}
+	}
+
+	a.channelsByID[c.ID] = &c
+	if c.Name != "" {
+		a.channelsByName[c.Name] = &c
+	}
+
+	if user != "" {
+		a.imsByUser[user] = &c
+	}
+
+	return &c
+}
+
+// storeUser adds a user to the cache, or replaces them, and returns the
+// cached user.
+func (a *Adapter) storeUser(u marvin.User) *marvin.User {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	if old, ok := a.usersByID[u.ID]; ok && old.Name != u.Name && a.usersByName[old.Name] == old {
+		delete(a.usersByName, old.Name)
+	}
+
+	a.usersByID[u.ID] = &u
+	a.usersByName[u.Name] = &u
+	return &u
+}
+
+// updateCache updates the cache from an event that changes a user or a
+// channel, and returns whether the event was one.
+func (a *Adapter) updateCache(body []byte) bool {
+	var e cacheEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return false
+	}
+
+	switch e.Type {
+	case "team_join", "user_change":
+		var u marvin.User
+		if err := json.Unmarshal(e.User, &u); err != nil {
+			log.Printf("slack: error unmarshaling %s event: %s", e.Type, err)
+			return true
+		}
+		a.storeUser(u)
+	case "channel_created", "channel_joined", "channel_rename", "channel_unarchive", "group_joined", "group_rename", "group_unarchive":
+		var c marvin.Channel
+		if err := json.Unmarshal(e.Channel, &c); err != nil {
+			log.Printf("slack: error unmarshaling %s event: %s", e.Type, err)
+			return true
+		}
+		a.storeChannel(c, "")
+	case "im_created":
+		var c marvin.Channel
+		var user string
+		if err := json.Unmarshal(e.Channel, &c); err != nil || json.Unmarshal(e.User, &user) != nil {
+			log.Printf("slack: error unmarshaling %s event", e.Type)
+			return true
+		}
+		a.storeChannel(c, user)
+	case "channel_deleted":
+		var id string
+		if err := json.Unmarshal(e.Channel, &id); err != nil {
+			log.Printf("slack: error unmarshaling %s event: %s", e.Type, err)
+			return true
+		}
+		a.deleteChannel(id)
+	default:
+		return false
+	}
+
+	return true
+}
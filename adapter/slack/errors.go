@@ -2,7 +2,14 @@ package slack
 
 // Slack errors
 const (
-	ErrHTTPStart = Error("failed to make call to rtm.start")
+	ErrHTTPStart        = Error("failed to make call to rtm.start")
+	ErrInvalidSignature = Error("invalid request signature")
+	ErrNoAppToken       = Error("no app-level token to open a socket mode connection with")
+	ErrNoIM             = Error("no direct message channel open with user")
+	ErrNoSigningSecret  = Error("no signing secret to verify events with")
+	ErrNotConnected     = Error("not connected to slack")
+	ErrQueueFull        = Error("outgoing message queue is full")
+	ErrStaleRequest     = Error("request timestamp is too old")
 )
 
 // Error describes a Slack error
@@ -0,0 +1,315 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pressly/chi"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// DefaultEventsPath is the path the Events API endpoint is mounted on.
+const DefaultEventsPath = "/slack/events"
+
+// eventTTL is how long event IDs are remembered, to ignore retries of
+// events that were already received.
+const eventTTL = time.Hour
+
+// maxEvents is the number of event IDs remembered before the expired
+// ones are forgotten.
+const maxEvents = 1024
+
+// maxEventAge is how far a request's timestamp may be off before it is
+// rejected, to prevent replay attacks.
+const maxEventAge = 5 * time.Minute
+
+// maxEventSize is the largest request body the Events API endpoint reads.
+const maxEventSize = 1 << 20
+
+// event describes an event as it comes from slack's Events API
+type event struct {
+	BotID    string `json:"bot_id"`
+	Channel  string `json:"channel"`
+	Subtype  string `json:"subtype"`
+	Text     string `json:"text"`
+	ThreadTS string `json:"thread_ts"`
+	TS       string `json:"ts"`
+	Type     string `json:"type"`
+	User     string `json:"user"`
+}
+
+// eventCallback describes a request from slack's Events API
+type eventCallback struct {
+	Challenge string          `json:"challenge"`
+	Event     json.RawMessage `json:"event"`
+	EventID   string          `json:"event_id"`
+	Type      string          `json:"type"`
+}
+
+// NewEventsAdapter creates a new slack adapter that receives messages
+// through the Events API, verifying requests with the app's signing secret.
+func NewEventsAdapter(token string, signingSecret string) *Adapter {
+	a := NewAdapter(token)
+	a.Mode = ModeEvents
+	a.SigningSecret = signingSecret
+	return a
+}
+
+// authenticate calls auth.test to find out who the adapter is.
+func (a *Adapter) authenticate() error {
+	var res authTest
+	if err := a.Client.call("auth.test", url.Values{}, &res); err != nil {
+		return err
+	}
+
+	self := marvin.User{ID: res.UserID, Name: res.User}
+
+	a.cacheMu.Lock()
+	a.self = self
+	a.usersByID[self.ID] = &self
+	a.usersByName[self.Name] = &self
+	a.cacheMu.Unlock()
+
+	return nil
+}
+
+// eventMessage updates the cache from an event, and converts message
+// events to a message. It returns nil if the event is not a message the
+// robot should handle.
+func (a *Adapter) eventMessage(body json.RawMessage) *marvin.Message {
+	if a.updateCache(body) {
+		return nil
+	}
+
+	var e event
+	if err := json.Unmarshal(body, &e); err != nil {
+		log.Printf("slack: error unmarshaling event: %s", err)
+		return nil
+	}
+
+	a.cacheMu.RLock()
+	self := a.self
+	a.cacheMu.RUnlock()
+
+	if e.Type != "message" || e.Subtype != "" || e.BotID != "" || e.User == self.ID {
+		return nil
+	}
+
+	channel := a.lookupChannel(e.Channel, "")
+	user := a.lookupUser(e.User, "")
+
+	text := e.Text
+	if channel != nil && channel.IsDM {
+		text = self.Name + " " + text
+	}
+
+	return &marvin.Message{
+		Channel:  channel,
+		User:     user,
+		Text:     a.removeFormatting(text),
+		ID:       e.TS,
+		ThreadID: threadID(e.TS, e.ThreadTS),
+	}
+}
+
+// handleEvents handles requests from slack's Events API.
+func (a *Adapter) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if err := a.verify(r.Header, body, now); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var cb eventCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cb.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(cb.Challenge))
+		return
+	}
+
+	if cb.Type != "event_callback" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.mu.Lock()
+	closed, eventQueue := a.closed, a.eventQueue
+	a.mu.Unlock()
+
+	if eventQueue == nil {
+		http.Error(w, ErrNotConnected.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case <-closed:
+		http.Error(w, ErrNotConnected.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if a.seen(cb.EventID, now) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Slack retries events that are not acknowledged within three seconds,
+	// so acknowledge them before looking anything up or delivering them.
+	// Events that do not fit in the queue are left for slack to retry.
+	select {
+	case eventQueue <- cb:
+		w.WriteHeader(http.StatusOK)
+	default:
+		a.forget(cb.EventID)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+// deliverEvent converts an event to a message and delivers it, returning
+// false if the adapter is closed first. An event that cannot be delivered
+// is forgotten, so that it is delivered if slack sends it again.
+func (a *Adapter) deliverEvent(cb eventCallback, closed <-chan struct{}, messages chan<- *marvin.Message) bool {
+	m := a.eventMessage(cb.Event)
+	if m == nil {
+		return true
+	}
+
+	select {
+	case messages <- m:
+		return true
+	case <-closed:
+		a.forget(cb.EventID)
+		return false
+	}
+}
+
+// receiveEvents delivers queued events one at a time, in the order they
+// were received, until the adapter is closed. Events still queued by then
+// are forgotten, so that they are delivered if slack sends them again.
+func (a *Adapter) receiveEvents(eventQueue <-chan eventCallback, closed <-chan struct{}, messages chan<- *marvin.Message) {
+	defer func() {
+		for {
+			select {
+			case cb := <-eventQueue:
+				a.forget(cb.EventID)
+			default:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case cb := <-eventQueue:
+			if !a.deliverEvent(cb, closed, messages) {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// resolve looks up a channel and a user by ID, falling back to the given
+// names for those that cannot be found, and returns them along with who
+// the adapter is.
+func (a *Adapter) resolve(channelID string, channelName string, userID string, userName string) (marvin.User, *marvin.Channel, *marvin.User) {
+	a.cacheMu.RLock()
+	self := a.self
+	a.cacheMu.RUnlock()
+
+	return self, a.lookupChannel(channelID, channelName), a.lookupUser(userID, userName)
+}
+
+// forget forgets an event that was seen but not delivered.
+func (a *Adapter) forget(eventID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.events, eventID)
+}
+
+// seen returns whether an event with the given ID was received before,
+// and remembers it otherwise.
+func (a *Adapter) seen(eventID string, now time.Time) bool {
+	if eventID == "" {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, ok := a.events[eventID]; ok && now.Sub(t) < eventTTL {
+		return true
+	}
+
+	if len(a.events) >= maxEvents {
+		for id, t := range a.events {
+			if now.Sub(t) >= eventTTL {
+				delete(a.events, id)
+			}
+		}
+	}
+
+	a.events[eventID] = now
+	return false
+}
+
+// verify checks the signature slack puts on requests from the Events API.
+func (a *Adapter) verify(header http.Header, body []byte, now time.Time) error {
+	if a.SigningSecret == "" {
+		return ErrInvalidSignature
+	}
+
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if d := now.Sub(time.Unix(seconds, 0)); d > maxEventAge || d < -maxEventAge {
+		return ErrStaleRequest
+	}
+
+	mac := hmac.New(sha256.New, []byte(a.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Mount mounts the endpoint the Events API posts to on the robot's
+// router. It does nothing in RTM mode.
+func (a *Adapter) Mount(router chi.Router) {
+	if a.Mode != ModeEvents {
+		return
+	}
+
+	router.Post(a.EventsPath, a.handleEvents)
+}
@@ -0,0 +1,315 @@
+package slack_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pressly/chi"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/adapter/slack"
+)
+
+var testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// newEventsRequest creates a signed request to the Events API endpoint.
+func newEventsRequest(body string, secret string, timestamp time.Time) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req := httptest.NewRequest("POST", slack.DefaultEventsPath, strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+// newEventsAPI creates a stand-in for slack's Web API that records the
+// messages posted to it.
+func newEventsAPI(t *testing.T, posted chan<- map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.Write([]byte(`{"ok":false,"error":"not_authed"}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/auth.test":
+			w.Write([]byte(`{"ok":true,"user":"marvin","user_id":"UBOT"}`))
+		case "/conversations.info":
+			if r.FormValue("channel") == "D1" {
+				w.Write([]byte(`{"ok":true,"channel":{"id":"D1","is_im":true,"user":"U1"}}`))
+				return
+			}
+			w.Write([]byte(`{"ok":true,"channel":{"id":"` + r.FormValue("channel") + `","name":"general"}}`))
+		case "/users.info":
+			w.Write([]byte(`{"ok":true,"user":{"id":"` + r.FormValue("user") + `","name":"someperson"}}`))
+		case "/chat.postMessage":
+			r.ParseForm()
+			posted <- map[string]string{"channel": r.FormValue("channel"), "text": r.FormValue("text")}
+			w.Write([]byte(`{"ok":true}`))
+		default:
+			t.Errorf("Unexpected call to %s", r.URL.Path)
+		}
+	}))
+}
+
+func TestEvents(t *testing.T) {
+	posted := make(chan map[string]string, 10)
+	api := newEventsAPI(t, posted)
+	defer api.Close()
+
+	adapter := slack.NewEventsAdapter(testToken, testSigningSecret)
+	adapter.Client.BaseURL = api.URL + "/"
+
+	router := chi.NewRouter()
+	adapter.Mount(router)
+
+	messages := make(chan *marvin.Message, 10)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	now := time.Now()
+	tests := []struct {
+		body    string
+		code    int
+		message string
+		secret  string
+		time    time.Time
+	}{
+		{
+			body:   `{"type":"url_verification","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`,
+			code:   http.StatusOK,
+			secret: "wrong",
+			time:   now,
+		},
+		{
+			body:   `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hello"}}`,
+			code:   http.StatusUnauthorized,
+			secret: testSigningSecret,
+			time:   now.Add(-10 * time.Minute),
+		},
+		{
+			body:    `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hello"}}`,
+			code:    http.StatusOK,
+			message: "hello",
+			secret:  testSigningSecret,
+			time:    now,
+		},
+		{
+			body:   `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hello"}}`,
+			code:   http.StatusOK,
+			secret: testSigningSecret,
+			time:   now,
+		},
+		{
+			body:   `{"type":"event_callback","event_id":"Ev2","event":{"type":"message","channel":"C1","user":"UBOT","text":"my own"}}`,
+			code:   http.StatusOK,
+			secret: testSigningSecret,
+			time:   now,
+		},
+		{
+			body:    `{"type":"event_callback","event_id":"Ev3","event":{"type":"message","channel":"D1","channel_type":"im","user":"U1","text":"help"}}`,
+			code:    http.StatusOK,
+			message: "marvin help",
+			secret:  testSigningSecret,
+			time:    now,
+		},
+	}
+
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newEventsRequest(test.body, test.secret, test.time))
+
+		if i == 0 {
+			// url_verification requests are signed too.
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%d: expected status %d, got %d", i, http.StatusUnauthorized, w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, newEventsRequest(test.body, testSigningSecret, test.time))
+			if w.Body.String() != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+				t.Errorf("%d: expected the challenge back, got %q", i, w.Body.String())
+			}
+		}
+
+		if w.Code != test.code {
+			t.Errorf("%d: expected status %d, got %d", i, test.code, w.Code)
+		}
+
+		// Events are delivered after they are acknowledged.
+		wait := 50 * time.Millisecond
+		if test.message != "" {
+			wait = time.Second
+		}
+
+		select {
+		case m := <-messages:
+			if m.Text != test.message {
+				t.Errorf("%d: expected message %q, got %q", i, test.message, m.Text)
+			}
+		case <-time.After(wait):
+			if test.message != "" {
+				t.Errorf("%d: expected message %q, got none", i, test.message)
+			}
+		}
+	}
+
+	m := &marvin.Message{
+		Channel: &marvin.Channel{ID: "C1"},
+		User:    &marvin.User{ID: "U0G9QF9C6", Name: "U0G9QF9C6"},
+	}
+	if err := adapter.Reply(m, "hi"); err != nil {
+		t.Fatalf("Reply should not have returned an error, got %s", err)
+	}
+
+	select {
+	case params := <-posted:
+		if params["channel"] != "C1" || params["text"] != "<@U0G9QF9C6> hi" {
+			t.Errorf("Posted the wrong message: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for chat.postMessage")
+	}
+
+	// Messages without a user, such as those from scheduled jobs, are not
+	// replied to with a mention.
+	if err := adapter.Reply(&marvin.Message{Channel: &marvin.Channel{ID: "C1"}}, "hi"); err != nil {
+		t.Fatalf("Reply should not have returned an error, got %s", err)
+	}
+
+	select {
+	case params := <-posted:
+		if params["channel"] != "C1" || params["text"] != "hi" {
+			t.Errorf("Posted the wrong message: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for chat.postMessage")
+	}
+}
+
+func TestEventsNotConnected(t *testing.T) {
+	posted := make(chan map[string]string)
+	api := newEventsAPI(t, posted)
+	defer api.Close()
+
+	adapter := slack.NewEventsAdapter(testToken, testSigningSecret)
+	adapter.Client.BaseURL = api.URL + "/"
+
+	router := chi.NewRouter()
+	adapter.Mount(router)
+
+	body := `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hello"}}`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newEventsRequest(body, testSigningSecret, time.Now()))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d before opening, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	messages := make(chan *marvin.Message, 1)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	// Slack's retry of the event is delivered.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newEventsRequest(body, testSigningSecret, time.Now()))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	select {
+	case m := <-messages:
+		if m.Text != "hello" {
+			t.Errorf("Expected message %q, got %q", "hello", m.Text)
+		}
+	case <-time.After(time.Second):
+		t.Error("The retried event should have been delivered")
+	}
+}
+
+func TestEventsOrder(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth.test":
+			w.Write([]byte(`{"ok":true,"user":"marvin","user_id":"UBOT"}`))
+		case "/conversations.info":
+			w.Write([]byte(`{"ok":true,"channel":{"id":"C1","name":"general"}}`))
+		case "/users.info":
+			// Looking up the first event's user takes longer than the
+			// second's, which must not overtake it.
+			if r.FormValue("user") == "U1" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			w.Write([]byte(`{"ok":true,"user":{"id":"` + r.FormValue("user") + `","name":"someperson"}}`))
+		}
+	}))
+	defer api.Close()
+
+	adapter := slack.NewEventsAdapter(testToken, testSigningSecret)
+	adapter.Client.BaseURL = api.URL + "/"
+
+	router := chi.NewRouter()
+	adapter.Mount(router)
+
+	messages := make(chan *marvin.Message, 2)
+	if err := adapter.Open(messages); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer adapter.Close()
+
+	bodies := []string{
+		`{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"first"}}`,
+		`{"type":"event_callback","event_id":"Ev2","event":{"type":"message","channel":"C1","user":"U2","text":"second"}}`,
+	}
+
+	for i, body := range bodies {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newEventsRequest(body, testSigningSecret, time.Now()))
+		if w.Code != http.StatusOK {
+			t.Errorf("%d: expected status %d, got %d", i, http.StatusOK, w.Code)
+		}
+	}
+
+	for _, text := range []string{"first", "second"} {
+		select {
+		case m := <-messages:
+			if m.Text != text {
+				t.Errorf("Expected message %q, got %q", text, m.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for message %q", text)
+		}
+	}
+}
+
+func TestEventsOpen(t *testing.T) {
+	posted := make(chan map[string]string)
+	api := newEventsAPI(t, posted)
+	defer api.Close()
+
+	adapter := slack.NewEventsAdapter(testToken, "")
+	adapter.Client.BaseURL = api.URL + "/"
+	if err := adapter.Open(make(chan *marvin.Message)); err != slack.ErrNoSigningSecret {
+		t.Errorf("Open should have returned ErrNoSigningSecret, got %v", err)
+	}
+
+	adapter = slack.NewEventsAdapter("xoxb-wrong", testSigningSecret)
+	adapter.Client.BaseURL = api.URL + "/"
+	if err := adapter.Open(make(chan *marvin.Message)); err == nil || err.Error() != "not_authed" {
+		t.Errorf("Open should have returned not_authed, got %v", err)
+	}
+}
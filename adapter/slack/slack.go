@@ -2,13 +2,28 @@ package slack
 
 import "github.com/chielkunkels/marvin"
 
+// im describes a direct message channel as it comes from slack's rtm api
+type im struct {
+	marvin.Channel
+	User string `json:"user"`
+}
+
 // message describes a message as it comes from slack's rtm api
 type message struct {
-	ID      int64  `json:"id"`
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
-	Type    string `json:"type"`
-	User    string `json:"user,omitempty"`
+	ID             int64  `json:"id"`
+	Channel        string `json:"channel"`
+	ReplyBroadcast bool   `json:"reply_broadcast,omitempty"`
+	Text           string `json:"text"`
+	ThreadTS       string `json:"thread_ts,omitempty"`
+	TS             string `json:"ts,omitempty"`
+	Type           string `json:"type"`
+	User           string `json:"user,omitempty"`
+}
+
+// ping describes a ping sent to slack's rtm api to keep the connection alive
+type ping struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
 }
 
 // rtmStart describes the structure of the rtm.start response
@@ -16,7 +31,7 @@ type rtmStart struct {
 	Channels []marvin.Channel `json:"channels"`
 	Err      string           `json:"error"`
 	Groups   []marvin.Channel `json:"groups"`
-	IMs      []marvin.Channel `json:"ims"`
+	IMs      []im             `json:"ims"`
 	Ok       bool             `json:"ok"`
 	Self     marvin.User      `json:"self"`
 	URL      string           `json:"url"`
@@ -0,0 +1,176 @@
+package slack
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// envelope describes a message from slack's Socket mode api
+type envelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Type       string          `json:"type"`
+}
+
+// interaction describes the payload of an interactive envelope
+type interaction struct {
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	CallbackID string `json:"callback_id"`
+	Channel    struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Type string `json:"type"`
+	User struct {
+		ID   string `json:"id"`
+		Name string `json:"username"`
+	} `json:"user"`
+}
+
+// slashCommand describes the payload of a slash_commands envelope
+type slashCommand struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	Command     string `json:"command"`
+	Text        string `json:"text"`
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name"`
+}
+
+// NewSocketAdapter creates a new slack adapter that receives messages
+// through Socket mode, connecting with the app-level token.
+func NewSocketAdapter(token string, appToken string) *Adapter {
+	a := NewAdapter(token)
+	a.AppToken = appToken
+	a.Mode = ModeSocket
+	return a
+}
+
+// commandMessage converts a slash command to a message directed at the
+// robot. `/marvin deploy` becomes `marvin deploy`, and `/deploy app`
+// becomes `marvin deploy app`.
+func (a *Adapter) commandMessage(payload json.RawMessage) *marvin.Message {
+	var c slashCommand
+	if err := json.Unmarshal(payload, &c); err != nil {
+		log.Printf("slack: error unmarshaling slash command: %s", err)
+		return nil
+	}
+
+	self, channel, user := a.resolve(c.ChannelID, c.ChannelName, c.UserID, c.UserName)
+
+	words := []string{self.Name}
+	if command := strings.TrimPrefix(c.Command, "/"); command != self.Name {
+		words = append(words, command)
+	}
+	words = append(words, a.removeFormatting(c.Text))
+
+	return &marvin.Message{
+		Channel: channel,
+		User:    user,
+		Text:    strings.TrimSpace(strings.Join(words, " ")),
+	}
+}
+
+// handleEnvelope acknowledges and handles a message from the Socket mode
+// api. It returns false when slack is about to disconnect or the adapter
+// is closed. Before slack disconnects, the connection is replaced by a new
+// one, so that nothing is missed in between.
+func (a *Adapter) handleEnvelope(ws *websocket.Conn, body []byte, closed <-chan struct{}, messages chan<- *marvin.Message) bool {
+	var e envelope
+	if err := json.Unmarshal(body, &e); err != nil {
+		log.Printf("slack: error unmarshaling envelope: %s", err)
+		return true
+	}
+
+	if e.Type == "disconnect" {
+		a.replace(ws, closed)
+		return false
+	}
+
+	if e.EnvelopeID != "" {
+		if err := a.writeTo(ws, map[string]string{"envelope_id": e.EnvelopeID}); err != nil {
+			log.Printf("slack: error acknowledging envelope %s: %s", e.EnvelopeID, err)
+		}
+	}
+
+	var m *marvin.Message
+	switch e.Type {
+	case "events_api":
+		var cb eventCallback
+		if err := json.Unmarshal(e.Payload, &cb); err != nil {
+			log.Printf("slack: error unmarshaling event: %s", err)
+			return true
+		}
+
+		if cb.Type != "event_callback" || a.seen(cb.EventID, time.Now()) {
+			return true
+		}
+
+		return a.deliverEvent(cb, closed, messages)
+	case "interactive":
+		m = a.interactionMessage(e.Payload)
+	case "slash_commands":
+		m = a.commandMessage(e.Payload)
+	}
+
+	if m == nil {
+		return true
+	}
+
+	select {
+	case messages <- m:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// interactionMessage converts an interaction, such as a button being
+// clicked, to a message directed at the robot. Its text is the value of
+// the action, or the interaction's callback ID, and its payload the raw
+// interaction.
+func (a *Adapter) interactionMessage(payload json.RawMessage) *marvin.Message {
+	var i interaction
+	if err := json.Unmarshal(payload, &i); err != nil {
+		log.Printf("slack: error unmarshaling interaction: %s", err)
+		return nil
+	}
+
+	self, channel, user := a.resolve(i.Channel.ID, i.Channel.Name, i.User.ID, i.User.Name)
+
+	text := i.CallbackID
+	if len(i.Actions) > 0 {
+		text = i.Actions[0].Value
+		if text == "" {
+			text = i.Actions[0].ActionID
+		}
+	}
+
+	return &marvin.Message{
+		Channel: channel,
+		User:    user,
+		Text:    strings.TrimSpace(self.Name + " " + text),
+		Payload: payload,
+	}
+}
+
+// openConnection calls apps.connections.open with the app-level token and
+// returns the URL of the websocket it points to.
+func (a *Adapter) openConnection() (string, error) {
+	var res appsConnectionsOpen
+	if err := a.Client.withToken(a.AppToken).call("apps.connections.open", url.Values{}, &res); err != nil {
+		return "", err
+	}
+
+	return res.URL, nil
+}
@@ -0,0 +1,264 @@
+package marvin
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Brain describes a key-value store in which robots and their plugins keep
+// state. Values are JSON documents; Set returns ErrInvalidValue for values
+// that are not. Get returns ErrNotFound for keys that do not exist and Keys
+// returns the matching keys in lexical order.
+type Brain interface {
+	Delete(key string) error
+	Get(key string) ([]byte, error)
+	Keys(prefix string) ([]string, error)
+	Set(key string, value []byte) error
+}
+
+// MemoryBrain describes a brain that keeps its contents in memory only.
+type MemoryBrain struct {
+	data map[string][]byte
+	mu   sync.RWMutex
+}
+
+// NewMemoryBrain creates a new memory brain and returns a pointer to it.
+func NewMemoryBrain() *MemoryBrain {
+	return &MemoryBrain{data: map[string][]byte{}}
+}
+
+// CompareAndSwap stores value, which must be a JSON document, under key if
+// the current value equals old.
+func (b *MemoryBrain) CompareAndSwap(key string, old []byte, value []byte) (bool, error) {
+	if err := ValidateValue(value); err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, ok := b.data[key]
+	if (old == nil) == ok || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	b.data[key] = append([]byte(nil), value...)
+	return true, nil
+}
+
+// Delete deletes the value stored under key.
+func (b *MemoryBrain) Delete(key string) error {
+	b.mu.Lock()
+	delete(b.data, key)
+	b.mu.Unlock()
+	return nil
+}
+
+// ForEach calls fn for every key starting with prefix, in lexical order,
+// stopping at the first error.
+func (b *MemoryBrain) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	keys, _ := b.Keys(prefix)
+	for _, key := range keys {
+		value, err := b.Get(key)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value stored under key.
+func (b *MemoryBrain) Get(key string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	value, ok := b.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// Keys returns all keys starting with prefix.
+func (b *MemoryBrain) Keys(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	keys := []string{}
+	for key := range b.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (b *MemoryBrain) Set(key string, value []byte) error {
+	if err := ValidateValue(value); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.data[key] = append([]byte(nil), value...)
+	b.mu.Unlock()
+	return nil
+}
+
+// Transaction calls fn with a brain whose changes are applied atomically if
+// fn returns nil. The brain is locked for the duration of the transaction,
+// so fn must only use the brain it is given.
+func (b *MemoryBrain) Transaction(fn func(Brain) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := NewMemoryBrain()
+	for key, value := range b.data {
+		tx.data[key] = value
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	b.data = tx.data
+	return nil
+}
+
+// ValidateValue returns ErrInvalidValue if value is not a JSON document.
+// Brains use it to reject such values.
+func ValidateValue(value []byte) error {
+	var raw json.RawMessage
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return ErrInvalidValue
+	}
+
+	return nil
+}
+
+// Namespace describes a part of a brain reserved for a single plugin. It
+// encodes values to and decodes them from JSON.
+type Namespace struct {
+	brain  Brain
+	prefix string
+}
+
+// NewNamespace creates a new namespace in brain and returns a pointer to it.
+func NewNamespace(brain Brain, name string) *Namespace {
+	return &Namespace{brain: brain, prefix: name + ":"}
+}
+
+// Delete deletes the value stored under key.
+func (n *Namespace) Delete(key string) error {
+	return n.brain.Delete(n.prefix + key)
+}
+
+// Get decodes the value stored under key into v.
+func (n *Namespace) Get(key string, v interface{}) error {
+	value, err := n.brain.Get(n.prefix + key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(value, v)
+}
+
+// Keys returns all keys in the namespace starting with prefix.
+func (n *Namespace) Keys(prefix string) ([]string, error) {
+	keys, err := n.brain.Keys(n.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, n.prefix)
+	}
+
+	return keys, nil
+}
+
+// Set encodes v and stores it under key.
+func (n *Namespace) Set(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return n.brain.Set(n.prefix+key, value)
+}
+
+// Namespace returns the namespace with the given name in the robot's brain.
+func (r *Robot) Namespace(name string) *Namespace {
+	return NewNamespace(r.Brain, name)
+}
+
+// Iterator describes a brain that can iterate over keys and values by prefix.
+// ForEach calls fn for every matching key in lexical order, stopping at the
+// first error. fn may write to the brain.
+type Iterator interface {
+	ForEach(prefix string, fn func(key string, value []byte) error) error
+}
+
+// Swapper describes a brain that can atomically replace a value.
+// CompareAndSwap stores value under key only if the current value equals
+// old, where a nil old means the key must not exist yet. It reports
+// whether the value was stored.
+type Swapper interface {
+	CompareAndSwap(key string, old []byte, value []byte) (bool, error)
+}
+
+// Transactor describes a brain that can apply several changes atomically.
+// Transaction calls fn with a brain whose changes are committed if fn
+// returns nil, and discarded otherwise.
+type Transactor interface {
+	Transaction(fn func(Brain) error) error
+}
+
+// CopyBrain copies all keys and values from src to dst, in a single
+// transaction if dst supports them. It returns the number of keys copied.
+func CopyBrain(dst Brain, src Brain) (int, error) {
+	keys, err := src.Keys("")
+	if err != nil {
+		return 0, err
+	}
+
+	copyAll := func(dst Brain) error {
+		for _, key := range keys {
+			value, err := src.Get(key)
+			if err != nil {
+				return err
+			}
+
+			if err := dst.Set(key, value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if t, ok := dst.(Transactor); ok {
+		err = t.Transaction(copyAll)
+	} else {
+		err = copyAll(dst)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,198 @@
+package bolt
+
+import (
+	"bytes"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// bucket is the name of the bucket all keys are stored in.
+var bucket = []byte("marvin")
+
+// Brain describes a brain backed by an embedded bolt database. Besides
+// the basic brain operations, it supports prefix iteration, transactions
+// and compare-and-swap.
+type Brain struct {
+	db *bbolt.DB
+}
+
+// NewBrain opens the bolt database at path, creating it if needed,
+// and returns a pointer to a brain backed by it.
+func NewBrain(path string) (*Brain, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Brain{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (b *Brain) Close() error {
+	return b.db.Close()
+}
+
+// CompareAndSwap stores value, which must be a JSON document, under key if
+// the current value equals old.
+func (b *Brain) CompareAndSwap(key string, old []byte, value []byte) (bool, error) {
+	if err := marvin.ValidateValue(value); err != nil {
+		return false, err
+	}
+
+	swapped := false
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+
+		current := bkt.Get([]byte(key))
+		if (old == nil) != (current == nil) || !bytes.Equal(current, old) {
+			return nil
+		}
+
+		swapped = true
+		return bkt.Put([]byte(key), value)
+	})
+
+	return swapped, err
+}
+
+// Delete deletes the value stored under key.
+func (b *Brain) Delete(key string) error {
+	return b.Transaction(func(tx marvin.Brain) error {
+		return tx.Delete(key)
+	})
+}
+
+// ForEach calls fn for every key starting with prefix, in lexical order,
+// stopping at the first error. The keys and values are copied out of the
+// database first, so fn may write to the brain.
+func (b *Brain) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	var keys []string
+	var values [][]byte
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return forEach(tx.Bucket(bucket), prefix, func(key string, value []byte) error {
+			keys = append(keys, key)
+			values = append(values, append([]byte(nil), value...))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value stored under key.
+func (b *Brain) Get(key string) ([]byte, error) {
+	var value []byte
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		value, err = (&txBrain{tx.Bucket(bucket)}).Get(key)
+		return err
+	})
+
+	return value, err
+}
+
+// Keys returns all keys starting with prefix.
+func (b *Brain) Keys(prefix string) ([]string, error) {
+	var keys []string
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		keys, err = (&txBrain{tx.Bucket(bucket)}).Keys(prefix)
+		return err
+	})
+
+	return keys, err
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (b *Brain) Set(key string, value []byte) error {
+	return b.Transaction(func(tx marvin.Brain) error {
+		return tx.Set(key, value)
+	})
+}
+
+// Transaction calls fn with a brain whose changes are committed atomically
+// if fn returns nil.
+func (b *Brain) Transaction(fn func(marvin.Brain) error) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&txBrain{tx.Bucket(bucket)})
+	})
+}
+
+// txBrain describes a brain operating within a single bolt transaction.
+type txBrain struct {
+	bucket *bbolt.Bucket
+}
+
+// Delete deletes the value stored under key.
+func (t *txBrain) Delete(key string) error {
+	return t.bucket.Delete([]byte(key))
+}
+
+// Get returns a copy of the value stored under key.
+func (t *txBrain) Get(key string) ([]byte, error) {
+	value := t.bucket.Get([]byte(key))
+	if value == nil {
+		return nil, marvin.ErrNotFound
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// Keys returns all keys starting with prefix.
+func (t *txBrain) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	err := forEach(t.bucket, prefix, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	return keys, err
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (t *txBrain) Set(key string, value []byte) error {
+	if err := marvin.ValidateValue(value); err != nil {
+		return err
+	}
+
+	return t.bucket.Put([]byte(key), value)
+}
+
+// forEach calls fn for every key in the bucket starting with prefix.
+func forEach(bkt *bbolt.Bucket, prefix string, fn func(key string, value []byte) error) error {
+	p := []byte(prefix)
+	c := bkt.Cursor()
+
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if err := fn(string(k), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package bolt_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/brain/bolt"
+	"github.com/chielkunkels/marvin/brain/braintest"
+)
+
+func newBrain(t *testing.T) (*bolt.Brain, string, func()) {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "brain.db")
+	brain, err := bolt.NewBrain(path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+
+	return brain, path, func() {
+		brain.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	braintest.Run(t, brain)
+}
+
+func TestBrain(t *testing.T) {
+	brain, path, cleanup := newBrain(t)
+	defer cleanup()
+
+	brain.Set("karma:ford", []byte(`7`))
+	brain.Set("karma:arthur", []byte(`42`))
+	brain.Set("other", []byte(`1`))
+	brain.Delete("other")
+
+	if err := brain.Set("invalid", []byte(`not json`)); err == nil {
+		t.Error("Set should have rejected a value that is not JSON")
+	}
+	brain.Close()
+
+	brain, err := bolt.NewBrain(path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+
+	if v, err := brain.Get("karma:arthur"); err != nil || string(v) != `42` {
+		t.Errorf("Get should have returned the persisted value, got %s, %v", v, err)
+	}
+
+	if _, err := brain.Get("other"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound for a deleted key, got %v", err)
+	}
+
+	keys, _ := brain.Keys("karma:")
+	if len(keys) != 2 || keys[0] != "karma:arthur" || keys[1] != "karma:ford" {
+		t.Errorf("Keys should have returned sorted keys with the prefix, got %v", keys)
+	}
+
+	var values []string
+	brain.ForEach("karma:", func(key string, value []byte) error {
+		values = append(values, key+"="+string(value))
+		return nil
+	})
+
+	if len(values) != 2 || values[0] != "karma:arthur=42" || values[1] != "karma:ford=7" {
+		t.Errorf("ForEach should have iterated over the prefix in order, got %v", values)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	tests := []struct {
+		old     []byte
+		value   []byte
+		swapped bool
+	}{
+		{nil, []byte(`1`), true},
+		{nil, []byte(`2`), false},
+		{[]byte(`2`), []byte(`3`), false},
+		{[]byte(`1`), []byte(`3`), true},
+	}
+
+	for i, test := range tests {
+		swapped, err := brain.CompareAndSwap("counter", test.old, test.value)
+		if err != nil || swapped != test.swapped {
+			t.Errorf("%d: expected swapped to be %t, got %t, %v", i, test.swapped, swapped, err)
+		}
+	}
+
+	if v, _ := brain.Get("counter"); string(v) != `3` {
+		t.Errorf("Expected the last swapped value, got %s", v)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	oops := errors.New("oops")
+	err := brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("a", []byte(`1`))
+		tx.Set("b", []byte(`2`))
+		return oops
+	})
+
+	if err != oops {
+		t.Errorf("Transaction should have returned the error, got %v", err)
+	}
+
+	if keys, _ := brain.Keys(""); len(keys) != 0 {
+		t.Errorf("A failed transaction should have been rolled back, got %v", keys)
+	}
+
+	brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("a", []byte(`1`))
+		tx.Set("b", []byte(`2`))
+		return nil
+	})
+
+	if keys, _ := brain.Keys(""); len(keys) != 2 {
+		t.Errorf("A successful transaction should have been committed, got %v", keys)
+	}
+}
@@ -0,0 +1,208 @@
+// Package braintest implements a conformance test for brains, which every
+// brain runs from its own tests so that they all behave the same way.
+package braintest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// Run tests the brain, which must be empty, against the Brain interface and
+// against the Iterator, Swapper and Transactor interfaces it implements.
+func Run(t *testing.T, brain marvin.Brain) {
+	testBrain(t, brain)
+
+	if iterator, ok := brain.(marvin.Iterator); ok {
+		testIterator(t, brain, iterator)
+	}
+
+	if swapper, ok := brain.(marvin.Swapper); ok {
+		testSwapper(t, brain, swapper)
+	}
+
+	if transactor, ok := brain.(marvin.Transactor); ok {
+		testTransactor(t, brain, transactor)
+	}
+}
+
+// clear deletes all keys from the brain.
+func clear(t *testing.T, brain marvin.Brain) {
+	keys, err := brain.Keys("")
+	if err != nil {
+		t.Fatalf("Keys should not have returned an error, got %s", err)
+	}
+
+	for _, key := range keys {
+		if err := brain.Delete(key); err != nil {
+			t.Fatalf("Delete should not have returned an error, got %s", err)
+		}
+	}
+}
+
+func testBrain(t *testing.T, brain marvin.Brain) {
+	defer clear(t, brain)
+
+	if _, err := brain.Get("missing"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound, got %v", err)
+	}
+
+	if err := brain.Delete("missing"); err != nil {
+		t.Errorf("Delete should not have returned an error for a missing key, got %s", err)
+	}
+
+	value := []byte(`{"a":1}`)
+	if err := brain.Set("b", value); err != nil {
+		t.Fatalf("Set should not have returned an error, got %s", err)
+	}
+	brain.Set("a:2", []byte(`2`))
+	brain.Set("a:1", []byte(`1`))
+	value[2] = 'x'
+
+	if v, err := brain.Get("b"); err != nil || string(v) != `{"a":1}` {
+		t.Errorf("Get should have returned a copy of the stored value, got %s, %v", v, err)
+	}
+
+	brain.Set("b", []byte(`"replaced"`))
+	if v, err := brain.Get("b"); err != nil || string(v) != `"replaced"` {
+		t.Errorf("Set should have replaced the value, got %s, %v", v, err)
+	}
+
+	for _, invalid := range []string{``, `{`, `not json`} {
+		if err := brain.Set("invalid", []byte(invalid)); err != marvin.ErrInvalidValue {
+			t.Errorf("Set should have returned ErrInvalidValue for %q, got %v", invalid, err)
+		}
+	}
+
+	if _, err := brain.Get("invalid"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound for a rejected value, got %v", err)
+	}
+
+	keys, err := brain.Keys("a:")
+	if err != nil || len(keys) != 2 || keys[0] != "a:1" || keys[1] != "a:2" {
+		t.Errorf("Keys should have returned sorted keys with the prefix, got %v, %v", keys, err)
+	}
+
+	if keys, err := brain.Keys("missing:"); err != nil || keys == nil || len(keys) != 0 {
+		t.Errorf("Keys should have returned no keys, got %v, %v", keys, err)
+	}
+
+	brain.Delete("b")
+	if _, err := brain.Get("b"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func testIterator(t *testing.T, brain marvin.Brain, iterator marvin.Iterator) {
+	defer clear(t, brain)
+
+	brain.Set("a:2", []byte(`2`))
+	brain.Set("a:1", []byte(`1`))
+	brain.Set("b", []byte(`3`))
+
+	var seen []string
+	err := iterator.ForEach("a:", func(key string, value []byte) error {
+		seen = append(seen, key+"="+string(value))
+		return nil
+	})
+	if err != nil || len(seen) != 2 || seen[0] != "a:1=1" || seen[1] != "a:2=2" {
+		t.Errorf("ForEach should have visited the keys with the prefix in order, got %v, %v", seen, err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = iterator.ForEach("", func(key string, value []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("ForEach should have stopped at the first error, got %v after %d calls", err, calls)
+	}
+
+	// Callbacks may write to the brain they are iterating over.
+	err = iterator.ForEach("a:", func(key string, value []byte) error {
+		return brain.Set(key, []byte(`0`))
+	})
+	if err != nil {
+		t.Errorf("ForEach should not have returned an error, got %s", err)
+	}
+
+	if v, _ := brain.Get("a:2"); string(v) != `0` {
+		t.Errorf("Set from within ForEach should have been stored, got %s", v)
+	}
+}
+
+func testSwapper(t *testing.T, brain marvin.Brain, swapper marvin.Swapper) {
+	defer clear(t, brain)
+
+	tests := []struct {
+		old     string
+		nilOld  bool
+		value   string
+		swapped bool
+		err     error
+	}{
+		{nilOld: true, value: `1`, swapped: true},
+		{nilOld: true, value: `2`},
+		{old: `2`, value: `3`},
+		{old: `1`, value: `1`, swapped: true},
+		{old: `1`, value: `2`, swapped: true},
+		{old: `2`, value: `{`, err: marvin.ErrInvalidValue},
+	}
+
+	for i, test := range tests {
+		old := []byte(test.old)
+		if test.nilOld {
+			old = nil
+		}
+
+		swapped, err := swapper.CompareAndSwap("counter", old, []byte(test.value))
+		if swapped != test.swapped || err != test.err {
+			t.Errorf("%d: CompareAndSwap should have returned %t, %v, got %t, %v", i, test.swapped, test.err, swapped, err)
+		}
+	}
+
+	if v, _ := brain.Get("counter"); string(v) != `2` {
+		t.Errorf("Expected the last swapped value, got %s", v)
+	}
+}
+
+func testTransactor(t *testing.T, brain marvin.Brain, transactor marvin.Transactor) {
+	defer clear(t, brain)
+
+	brain.Set("a", []byte(`1`))
+
+	fail := errors.New("fail")
+	err := transactor.Transaction(func(tx marvin.Brain) error {
+		tx.Set("a", []byte(`2`))
+		tx.Set("b", []byte(`2`))
+		return fail
+	})
+	if err != fail {
+		t.Errorf("Transaction should have returned the error, got %v", err)
+	}
+
+	if v, _ := brain.Get("a"); string(v) != `1` {
+		t.Errorf("A failed transaction should have been rolled back, got %s", v)
+	}
+
+	if _, err := brain.Get("b"); err != marvin.ErrNotFound {
+		t.Errorf("A failed transaction should have been rolled back, got %v", err)
+	}
+
+	err = transactor.Transaction(func(tx marvin.Brain) error {
+		if err := tx.Set("b", []byte(`{`)); err != marvin.ErrInvalidValue {
+			t.Errorf("Set should have returned ErrInvalidValue within a transaction, got %v", err)
+		}
+
+		return tx.Set("b", []byte(`2`))
+	})
+	if err != nil {
+		t.Errorf("Transaction should not have returned an error, got %s", err)
+	}
+
+	if v, _ := brain.Get("b"); string(v) != `2` {
+		t.Errorf("A transaction should have been committed, got %s", v)
+	}
+}
@@ -0,0 +1,156 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// Brain describes a brain that keeps its contents in a JSON file. The
+// file is rewritten atomically on every change, so it is never left
+// half-written when the robot dies mid-write.
+type Brain struct {
+	data map[string]json.RawMessage
+	mu   sync.RWMutex
+	path string
+}
+
+// NewBrain creates a new file brain backed by the file at path, loading its
+// contents if it exists, and returns a pointer to it.
+func NewBrain(path string) (*Brain, error) {
+	b := &Brain{data: map[string]json.RawMessage{}, path: path}
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &b.data); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Delete deletes the value stored under key.
+func (b *Brain) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, ok := b.data[key]
+	if !ok {
+		return nil
+	}
+
+	delete(b.data, key)
+	if err := b.save(); err != nil {
+		b.data[key] = value
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the value stored under key.
+func (b *Brain) Get(key string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	value, ok := b.data[key]
+	if !ok {
+		return nil, marvin.ErrNotFound
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// Keys returns all keys starting with prefix.
+func (b *Brain) Keys(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	keys := []string{}
+	for key := range b.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (b *Brain) Set(key string, value []byte) error {
+	if err := marvin.ValidateValue(value); err != nil {
+		return err
+	}
+	raw := json.RawMessage(append([]byte(nil), value...))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, existed := b.data[key]
+	b.data[key] = raw
+	if err := b.save(); err != nil {
+		if existed {
+			b.data[key] = old
+		} else {
+			delete(b.data, key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// save writes the brain's contents to a temporary file next to the
+// brain's file, then renames it over the brain's file.
+func (b *Brain) save() error {
+	body, err := json.Marshal(b.data)
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(b.path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), b.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
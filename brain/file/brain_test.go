@@ -0,0 +1,110 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/brain/braintest"
+	"github.com/chielkunkels/marvin/brain/file"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestNewBrain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "brain.json")
+	if _, err := file.NewBrain(path); err != nil {
+		t.Errorf("NewBrain should not have failed for a missing file, got %s", err)
+	}
+
+	ioutil.WriteFile(path, []byte("{"), 0600)
+	if _, err := file.NewBrain(path); err == nil {
+		t.Error("NewBrain should have failed for a corrupt file")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	brain, err := file.NewBrain(filepath.Join(dir, "brain.json"))
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+
+	braintest.Run(t, brain)
+}
+
+func TestBrain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "brain.json")
+	brain, _ := file.NewBrain(path)
+
+	if err := brain.Set("karma:arthur", []byte(`{"points":42}`)); err != nil {
+		t.Fatalf("Set should not have returned an error, got %s", err)
+	}
+
+	brain.Set("karma:ford", []byte(`7`))
+	brain.Set("other", []byte(`"value"`))
+
+	if err := brain.Set("invalid", []byte(`{`)); err == nil {
+		t.Error("Set should have rejected a value that is not JSON")
+	}
+
+	brain.Delete("other")
+
+	reloaded, err := file.NewBrain(path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+
+	if v, err := reloaded.Get("karma:arthur"); err != nil || string(v) != `{"points":42}` {
+		t.Errorf("Get should have returned the persisted value, got %s, %v", v, err)
+	}
+
+	if _, err := reloaded.Get("other"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound for a deleted key, got %v", err)
+	}
+
+	if _, err := reloaded.Get("invalid"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound for a rejected key, got %v", err)
+	}
+
+	keys, _ := reloaded.Keys("karma:")
+	if len(keys) != 2 || keys[0] != "karma:arthur" || keys[1] != "karma:ford" {
+		t.Errorf("Keys should have returned sorted keys with the prefix, got %v", keys)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Temporary files should have been cleaned up, found %d files", len(files))
+	}
+}
+
+func TestBrainSaveError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	brain, _ := file.NewBrain(filepath.Join(dir, "missing", "brain.json"))
+	if err := brain.Set("key", []byte(`1`)); err == nil {
+		t.Fatal("Set should have failed to save")
+	}
+
+	if _, err := brain.Get("key"); err != marvin.ErrNotFound {
+		t.Errorf("A failed Set should not have changed the brain, got %v", err)
+	}
+}
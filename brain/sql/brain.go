@@ -0,0 +1,328 @@
+// Package sql implements a brain on top of database/sql. Any driver can be
+// used; drivers using numbered placeholders, such as postgres, are
+// recognised by name.
+package sql
+
+import (
+	"bytes"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// AuditEntry describes an entry in the audit log.
+type AuditEntry struct {
+	Action    string
+	CreatedAt time.Time
+	Detail    string
+	UserID    string
+}
+
+// Brain describes a brain backed by a SQL database. Besides the basic
+// brain operations it supports transactions and compare-and-swap, and
+// keeps the robot's audit log in a table of its own. The scheduler's jobs
+// and the reminders plugin's reminders are kept in typed tables too, which
+// have a column for each of their fields worth querying.
+type Brain struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewBrain opens the database using the named driver, applies Migrations
+// and returns a pointer to a brain backed by it.
+func NewBrain(driver string, dataSource string) (*Brain, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(db, driver, Migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Brain{db: db, dialect: newDialect(driver)}, nil
+}
+
+// Audit adds an entry to the audit log.
+func (b *Brain) Audit(userID string, action string, detail string) error {
+	_, err := b.db.Exec(b.dialect.rebind(`INSERT INTO audit_log (created_at, user_id, action, detail) VALUES (?, ?, ?, ?)`), time.Now().UTC(), userID, action, detail)
+	return err
+}
+
+// AuditLog returns the most recent entries in the audit log, newest first.
+func (b *Brain) AuditLog(limit int) ([]AuditEntry, error) {
+	rows, err := b.db.Query(b.dialect.rebind(`SELECT created_at, user_id, action, detail FROM audit_log ORDER BY created_at DESC LIMIT ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.CreatedAt, &e.UserID, &e.Action, &e.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// Close closes the underlying database.
+func (b *Brain) Close() error {
+	return b.db.Close()
+}
+
+// CompareAndSwap stores value, which must be a JSON document, under key if
+// the current value equals old. A nil old value means the key must not
+// exist yet.
+func (b *Brain) CompareAndSwap(key string, old []byte, value []byte) (bool, error) {
+	if err := marvin.ValidateValue(value); err != nil {
+		return false, err
+	}
+
+	t := tableFor(key)
+	values := t.values(key, value)
+
+	if old == nil {
+		res, err := b.db.Exec(b.dialect.rebind(b.dialect.insertIgnore(t)), values...)
+		if err != nil {
+			return false, err
+		}
+
+		n, err := res.RowsAffected()
+		return n == 1, err
+	}
+
+	res, err := b.db.Exec(b.dialect.rebind(b.dialect.update(t)), append(values[1:], key, string(old))...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 1 {
+		return n == 1, err
+	}
+
+	// MySQL does not count rows that an update leaves unchanged.
+	if !bytes.Equal(old, value) {
+		return false, nil
+	}
+
+	current, err := b.Get(key)
+	if err == marvin.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil && bytes.Equal(current, old), err
+}
+
+// Delete deletes the value stored under key.
+func (b *Brain) Delete(key string) error {
+	return (&txBrain{b.db, b}).Delete(key)
+}
+
+// Get returns the value stored under key.
+func (b *Brain) Get(key string) ([]byte, error) {
+	return (&txBrain{b.db, b}).Get(key)
+}
+
+// Keys returns all keys starting with prefix.
+func (b *Brain) Keys(prefix string) ([]string, error) {
+	return (&txBrain{b.db, b}).Keys(prefix)
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (b *Brain) Set(key string, value []byte) error {
+	return b.Transaction(func(tx marvin.Brain) error {
+		return tx.Set(key, value)
+	})
+}
+
+// Transaction calls fn with a brain whose changes are committed atomically
+// if fn returns nil.
+func (b *Brain) Transaction(fn func(marvin.Brain) error) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&txBrain{tx, b}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// queryer describes what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	Query(string, ...interface{}) (*sql.Rows, error)
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+// txBrain describes a brain operating on a database or within a transaction.
+type txBrain struct {
+	q     queryer
+	brain *Brain
+}
+
+// Delete deletes the value stored under key.
+func (t *txBrain) Delete(key string) error {
+	_, err := t.q.Exec(t.brain.dialect.rebind(`DELETE FROM `+tableFor(key).name+` WHERE name = ?`), key)
+	return err
+}
+
+// Get returns the value stored under key.
+func (t *txBrain) Get(key string) ([]byte, error) {
+	var value string
+	err := t.q.QueryRow(t.brain.dialect.rebind(`SELECT value FROM `+tableFor(key).name+` WHERE name = ?`), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, marvin.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return []byte(value), nil
+}
+
+// Keys returns all keys starting with prefix, in lexical order.
+func (t *txBrain) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	for _, table := range tablesFor(prefix) {
+		var err error
+		if keys, err = t.keys(table, prefix, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	// Collations differ between databases, so sort bytewise like the other brains.
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// keys appends the keys in the table starting with prefix to keys.
+func (t *txBrain) keys(table table, prefix string, keys []string) ([]string, error) {
+	rows, err := t.q.Query(t.brain.dialect.rebind(`SELECT name FROM `+table.name+` WHERE substr(name, 1, ?) = ?`), utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Set stores value, which must be a JSON document, under key.
+func (t *txBrain) Set(key string, value []byte) error {
+	if err := marvin.ValidateValue(value); err != nil {
+		return err
+	}
+
+	table := tableFor(key)
+	_, err := t.q.Exec(t.brain.dialect.rebind(t.brain.dialect.upsert(table)), table.values(key, value)...)
+	return err
+}
+
+// dialect describes the differences between databases that matter to the brain.
+type dialect struct {
+	mysql    bool
+	numbered bool
+}
+
+// newDialect returns the dialect for the named driver.
+func newDialect(driver string) dialect {
+	switch driver {
+	case "mysql":
+		return dialect{mysql: true}
+	case "postgres", "pgx", "cloudsqlpostgres":
+		return dialect{numbered: true}
+	default:
+		return dialect{}
+	}
+}
+
+// insert returns the statement inserting a row into the table, taking the
+// values of the table's columns in order.
+func (d dialect) insert(t table) string {
+	names := t.names()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	return `INSERT INTO ` + t.name + ` (` + strings.Join(names, ", ") + `) VALUES (` + placeholders + `)`
+}
+
+// insertIgnore returns the statement inserting a row into the table,
+// unless its name exists already.
+func (d dialect) insertIgnore(t table) string {
+	if d.mysql {
+		return strings.Replace(d.insert(t), "INSERT", "INSERT IGNORE", 1)
+	}
+
+	return d.insert(t) + ` ON CONFLICT (name) DO NOTHING`
+}
+
+// update returns the statement replacing the value and typed columns of
+// the row with the given name and value, taking the new values of the
+// columns after name in order, followed by the name and the old value.
+func (d dialect) update(t table) string {
+	var sets []string
+	for _, name := range t.names()[1:] {
+		sets = append(sets, name+` = ?`)
+	}
+
+	return `UPDATE ` + t.name + ` SET ` + strings.Join(sets, ", ") + ` WHERE name = ? AND value = ?`
+}
+
+// upsert returns the statement inserting a row into the table, or
+// replacing its value and typed columns if its name exists already.
+func (d dialect) upsert(t table) string {
+	var sets []string
+	for _, name := range t.names()[1:] {
+		if d.mysql {
+			sets = append(sets, name+` = VALUES(`+name+`)`)
+		} else {
+			sets = append(sets, name+` = excluded.`+name)
+		}
+	}
+
+	if d.mysql {
+		return d.insert(t) + ` ON DUPLICATE KEY UPDATE ` + strings.Join(sets, ", ")
+	}
+
+	return d.insert(t) + ` ON CONFLICT (name) DO UPDATE SET ` + strings.Join(sets, ", ")
+}
+
+// rebind replaces the ? placeholders in query with the dialect's own.
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var buf bytes.Buffer
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+
+	return buf.String()
+}
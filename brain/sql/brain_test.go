@@ -0,0 +1,277 @@
+package sql_test
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/brain/braintest"
+	brainsql "github.com/chielkunkels/marvin/brain/sql"
+)
+
+func newBrain(t *testing.T) (*brainsql.Brain, string, func()) {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "brain.db")
+	brain, err := brainsql.NewBrain("sqlite3", path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+
+	return brain, path, func() {
+		brain.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	braintest.Run(t, brain)
+}
+
+func TestBrain(t *testing.T) {
+	brain, path, cleanup := newBrain(t)
+	defer cleanup()
+
+	brain.Set("karma:ford", []byte(`7`))
+	brain.Set("karma:arthur", []byte(`42`))
+	brain.Set("karma:arthur", []byte(`43`))
+	brain.Set("KARMA:zaphod", []byte(`1`))
+	brain.Set("other", []byte(`1`))
+	brain.Delete("other")
+	brain.Close()
+
+	brain, err := brainsql.NewBrain("sqlite3", path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error when reopening, got %s", err)
+	}
+
+	if v, err := brain.Get("karma:arthur"); err != nil || string(v) != `43` {
+		t.Errorf("Get should have returned the persisted value, got %s, %v", v, err)
+	}
+
+	if _, err := brain.Get("other"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound for a deleted key, got %v", err)
+	}
+
+	keys, _ := brain.Keys("karma:")
+	if len(keys) != 2 || keys[0] != "karma:arthur" || keys[1] != "karma:ford" {
+		t.Errorf("Keys should have returned sorted keys with the exact prefix, got %v", keys)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	tests := []struct {
+		old     []byte
+		value   []byte
+		swapped bool
+	}{
+		{nil, []byte(`1`), true},
+		{nil, []byte(`2`), false},
+		{[]byte(`2`), []byte(`3`), false},
+		{[]byte(`1`), []byte(`3`), true},
+		{[]byte(`3`), []byte(`3`), true},
+	}
+
+	for i, test := range tests {
+		swapped, err := brain.CompareAndSwap("counter", test.old, test.value)
+		if err != nil || swapped != test.swapped {
+			t.Errorf("%d: expected swapped to be %t, got %t, %v", i, test.swapped, swapped, err)
+		}
+	}
+
+	if v, _ := brain.Get("counter"); string(v) != `3` {
+		t.Errorf("Expected the last swapped value, got %s", v)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	oops := errors.New("oops")
+	err := brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("a", []byte(`1`))
+		tx.Set("b", []byte(`2`))
+		return oops
+	})
+
+	if err != oops {
+		t.Errorf("Transaction should have returned the error, got %v", err)
+	}
+
+	if keys, _ := brain.Keys(""); len(keys) != 0 {
+		t.Errorf("A failed transaction should have been rolled back, got %v", keys)
+	}
+
+	brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("a", []byte(`1`))
+		tx.Set("b", []byte(`2`))
+		return nil
+	})
+
+	if keys, _ := brain.Keys(""); len(keys) != 2 {
+		t.Errorf("A successful transaction should have been committed, got %v", keys)
+	}
+}
+
+func TestTypedTables(t *testing.T) {
+	brain, path, cleanup := newBrain(t)
+	defer cleanup()
+
+	job := `{"at":"2030-01-02T03:04:05Z","data":null,"id":"1","kind":"reminder"}`
+	reminder := `{"at":"2030-01-02T03:04:05Z","channel":"C1","creator":"U1","creatorName":"arthur","id":"3","target":"me","text":"stretch"}`
+
+	brain.Set("scheduler:1", []byte(job))
+	brain.Set("reminders:reminder:3", []byte(reminder))
+	brain.Set("reminders:seq", []byte(`3`))
+	if swapped, err := brain.CompareAndSwap("reminders:reminder:3", []byte(reminder), []byte(`{"channel":"C2"}`)); !swapped || err != nil {
+		t.Errorf("CompareAndSwap should have swapped the reminder, got %t, %v", swapped, err)
+	}
+
+	if v, err := brain.Get("scheduler:1"); err != nil || string(v) != job {
+		t.Errorf("Get should have returned the job, got %s, %v", v, err)
+	}
+
+	keys, _ := brain.Keys("reminders:")
+	if len(keys) != 2 || keys[0] != "reminders:reminder:3" || keys[1] != "reminders:seq" {
+		t.Errorf("Keys should have returned the keys from all tables, got %v", keys)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var kind string
+	var runAt time.Time
+	if err := db.QueryRow(`SELECT kind, run_at FROM jobs WHERE name = 'scheduler:1'`).Scan(&kind, &runAt); err != nil {
+		t.Fatalf("The job should have been stored in the jobs table, got %s", err)
+	}
+
+	if kind != "reminder" || !runAt.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected the job's columns to be filled, got %q, %s", kind, runAt)
+	}
+
+	var channel string
+	var creator sql.NullString
+	if err := db.QueryRow(`SELECT channel, creator FROM reminders WHERE name = 'reminders:reminder:3'`).Scan(&channel, &creator); err != nil {
+		t.Fatalf("The reminder should have been stored in the reminders table, got %s", err)
+	}
+
+	if channel != "C2" || creator.Valid {
+		t.Errorf("Expected the reminder's columns to be updated, got %q, %v", channel, creator)
+	}
+
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM brain`).Scan(&n)
+	if n != 1 {
+		t.Errorf("Expected only the untyped key in the brain table, got %d rows", n)
+	}
+}
+
+func TestMigrateTypedTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "brain.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A database from before the typed tables existed.
+	if err := brainsql.Migrate(db, "sqlite3", brainsql.Migrations[:2]); err != nil {
+		t.Fatalf("Migrate should not have returned an error, got %s", err)
+	}
+	db.Exec(`INSERT INTO brain (name, value) VALUES ('scheduler:1', '{"kind":"reminder"}'), ('other', '1')`)
+
+	brain, err := brainsql.NewBrain("sqlite3", path)
+	if err != nil {
+		t.Fatalf("NewBrain should not have returned an error, got %s", err)
+	}
+	defer brain.Close()
+
+	if v, err := brain.Get("scheduler:1"); err != nil || string(v) != `{"kind":"reminder"}` {
+		t.Errorf("Get should have returned the moved job, got %s, %v", v, err)
+	}
+
+	var kind string
+	if err := db.QueryRow(`SELECT kind FROM jobs WHERE name = 'scheduler:1'`).Scan(&kind); err != nil || kind != "reminder" {
+		t.Errorf("The job should have been moved to the jobs table, got %q, %v", kind, err)
+	}
+
+	if keys, _ := brain.Keys(""); len(keys) != 2 {
+		t.Errorf("Expected both keys to be kept, got %v", keys)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	brain, _, cleanup := newBrain(t)
+	defer cleanup()
+
+	brain.Audit("U1", "grant", "admin to U2")
+	brain.Audit("U1", "revoke", "admin from U2")
+
+	entries, err := brain.AuditLog(1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("AuditLog should have returned 1 entry, got %v, %v", entries, err)
+	}
+
+	if entries[0].Action != "revoke" || entries[0].UserID != "U1" {
+		t.Errorf("AuditLog should have returned the newest entry first, got %+v", entries[0])
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "brain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []brainsql.Migration{
+		{Version: 2, Name: "add column", Up: []string{`ALTER TABLE notes ADD COLUMN author TEXT`}},
+		{Version: 1, Name: "create notes", Up: []string{`CREATE TABLE notes (text TEXT)`}},
+	}
+
+	if err := brainsql.Migrate(db, "sqlite3", migrations); err != nil {
+		t.Fatalf("Migrate should have applied the migrations in order, got %s", err)
+	}
+
+	if err := brainsql.Migrate(db, "sqlite3", migrations); err != nil {
+		t.Errorf("Migrate should have skipped applied migrations, got %s", err)
+	}
+
+	migrations = append(migrations, brainsql.Migration{Version: 3, Name: "broken", Up: []string{`NOT SQL`}})
+	err = brainsql.Migrate(db, "sqlite3", migrations)
+	if merr, ok := err.(*brainsql.MigrationError); !ok || merr.Migration.Version != 3 {
+		t.Errorf("Migrate should have returned a MigrationError for the broken migration, got %v", err)
+	}
+}
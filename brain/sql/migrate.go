@@ -0,0 +1,176 @@
+package sql
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Migration describes a versioned change to the database schema. Run, if
+// set, is called within the migration's transaction after the Up
+// statements, for changes to the data that SQL alone cannot express.
+type Migration struct {
+	Name    string
+	Run     func(tx *sql.Tx, driver string) error
+	Up      []string
+	Version int
+}
+
+// Migrations are the migrations applied by NewBrain.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create brain",
+		Up: []string{
+			`CREATE TABLE brain (name VARCHAR(255) NOT NULL PRIMARY KEY, value TEXT NOT NULL)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create audit log",
+		Up: []string{
+			`CREATE TABLE audit_log (created_at TIMESTAMP NOT NULL, user_id VARCHAR(255) NOT NULL, action VARCHAR(255) NOT NULL, detail TEXT NOT NULL)`,
+			`CREATE INDEX audit_log_created_at ON audit_log (created_at)`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create jobs and reminders",
+		Up: []string{
+			`CREATE TABLE jobs (name VARCHAR(255) NOT NULL PRIMARY KEY, value TEXT NOT NULL, kind VARCHAR(255), run_at TIMESTAMP NULL)`,
+			`CREATE INDEX jobs_run_at ON jobs (run_at)`,
+			`CREATE TABLE reminders (name VARCHAR(255) NOT NULL PRIMARY KEY, value TEXT NOT NULL, channel VARCHAR(255), creator VARCHAR(255), every VARCHAR(255), remind_at TIMESTAMP NULL, target VARCHAR(255), text TEXT)`,
+			`CREATE INDEX reminders_creator ON reminders (creator)`,
+			`CREATE INDEX reminders_remind_at ON reminders (remind_at)`,
+		},
+		Run: func(tx *sql.Tx, driver string) error {
+			return moveKeys(tx, newDialect(driver), typedTables)
+		},
+	},
+}
+
+// Migrate applies the migrations the database has not seen yet, in order
+// of version, each in its own transaction. Applied versions are recorded
+// in the schema_migrations table, which also makes it safe for several
+// processes to migrate the same database at once: a migration that fails
+// because another process applied it first is skipped.
+func Migrate(db *sql.DB, driver string, migrations []Migration) error {
+	d := newDialect(driver)
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	row := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err := row.Scan(&current); err != nil {
+		return err
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for _, m := range sorted {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := migrate(db, driver, m); err != nil {
+			if ok, _ := applied(db, d, m.Version); ok {
+				continue
+			}
+
+			return &MigrationError{Err: err, Migration: m}
+		}
+	}
+
+	return nil
+}
+
+// applied returns whether the migration with the given version was applied.
+func applied(db *sql.DB, d dialect, version int) (bool, error) {
+	var n int
+	err := db.QueryRow(d.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&n)
+	return n > 0, err
+}
+
+// migrate applies a single migration.
+func migrate(db *sql.DB, driver string, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.Up {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if m.Run != nil {
+		if err := m.Run(tx, driver); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(newDialect(driver).rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`), m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveKeys moves the keys that the given typed tables hold out of the
+// brain table and into their tables.
+func moveKeys(tx *sql.Tx, d dialect, tables []table) error {
+	for _, t := range tables {
+		rows, err := tx.Query(d.rebind(`SELECT name, value FROM brain WHERE substr(name, 1, ?) = ?`), utf8.RuneCountInString(t.prefix), t.prefix)
+		if err != nil {
+			return err
+		}
+
+		values := map[string]string{}
+		for rows.Next() {
+			var name, value string
+			if err := rows.Scan(&name, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			values[name] = value
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for name, value := range values {
+			if _, err := tx.Exec(d.rebind(d.upsert(t)), t.values(name, []byte(value))...); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(d.rebind(`DELETE FROM brain WHERE name = ?`), name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// MigrationError describes a migration that failed to apply.
+type MigrationError struct {
+	Err       error
+	Migration Migration
+}
+
+// Error returns the error
+func (e *MigrationError) Error() string {
+	return "migration " + e.Migration.Name + " failed: " + e.Err.Error()
+}
@@ -0,0 +1,137 @@
+package sql
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Column types
+const (
+	columnText columnType = iota
+	columnTime
+)
+
+// columnType describes the type of a typed table's column.
+type columnType int
+
+// column describes a column of a typed table, filled from the field of
+// the same JSON value it is named after.
+type column struct {
+	field string
+	name  string
+	typ   columnType
+}
+
+// table describes a table holding the values of the keys starting with
+// prefix. Every table has a name column holding the key and a value column
+// holding the JSON value; typed tables add a column for each of the value's
+// fields worth querying on their own.
+type table struct {
+	columns []column
+	name    string
+	prefix  string
+}
+
+// brainTable is the table holding the keys no typed table holds.
+var brainTable = table{name: "brain"}
+
+// typedTables are the typed tables for the data stored by the robot's
+// scheduler and the bundled plugins.
+var typedTables = []table{
+	{
+		columns: []column{
+			{field: "kind", name: "kind", typ: columnText},
+			{field: "at", name: "run_at", typ: columnTime},
+		},
+		name:   "jobs",
+		prefix: "scheduler:",
+	},
+	{
+		columns: []column{
+			{field: "channel", name: "channel", typ: columnText},
+			{field: "creator", name: "creator", typ: columnText},
+			{field: "every", name: "every", typ: columnText},
+			{field: "at", name: "remind_at", typ: columnTime},
+			{field: "target", name: "target", typ: columnText},
+			{field: "text", name: "text", typ: columnText},
+		},
+		name:   "reminders",
+		prefix: "reminders:reminder:",
+	},
+}
+
+// tableFor returns the table holding the given key.
+func tableFor(key string) table {
+	for _, t := range typedTables {
+		if strings.HasPrefix(key, t.prefix) {
+			return t
+		}
+	}
+
+	return brainTable
+}
+
+// tablesFor returns the tables that may hold keys starting with prefix.
+func tablesFor(prefix string) []table {
+	tables := []table{brainTable}
+	for _, t := range typedTables {
+		if strings.HasPrefix(t.prefix, prefix) || strings.HasPrefix(prefix, t.prefix) {
+			tables = append(tables, t)
+		}
+	}
+
+	return tables
+}
+
+// names returns the names of the table's columns, starting with the name
+// and value columns.
+func (t table) names() []string {
+	names := []string{"name", "value"}
+	for _, c := range t.columns {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+// values returns the values of the table's columns for the given key and
+// JSON value, in the order of names. Fields that are missing, or that do
+// not have the column's type, are stored as NULL.
+func (t table) values(key string, value []byte) []interface{} {
+	values := []interface{}{key, string(value)}
+	if len(t.columns) == 0 {
+		return values
+	}
+
+	fields := map[string]json.RawMessage{}
+	json.Unmarshal(value, &fields)
+
+	for _, c := range t.columns {
+		values = append(values, c.value(fields[c.field]))
+	}
+
+	return values
+}
+
+// value converts a JSON field to the column's type, or returns nil.
+func (c column) value(field json.RawMessage) interface{} {
+	if field == nil {
+		return nil
+	}
+
+	switch c.typ {
+	case columnTime:
+		var v time.Time
+		if err := json.Unmarshal(field, &v); err != nil || v.IsZero() {
+			return nil
+		}
+		return v.UTC()
+	default:
+		var v string
+		if err := json.Unmarshal(field, &v); err != nil {
+			return nil
+		}
+		return v
+	}
+}
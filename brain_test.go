@@ -0,0 +1,140 @@
+package marvin_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/brain/braintest"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func TestMemoryBrain(t *testing.T) {
+	brain := marvin.NewMemoryBrain()
+
+	if _, err := brain.Get("missing"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound, got %v", err)
+	}
+
+	value := []byte(`{"a":1}`)
+	brain.Set("b", value)
+	brain.Set("a:2", []byte(`2`))
+	brain.Set("a:1", []byte(`1`))
+	value[2] = 'x'
+
+	if v, err := brain.Get("b"); err != nil || string(v) != `{"a":1}` {
+		t.Errorf("Get should have returned a copy of the stored value, got %s, %v", v, err)
+	}
+
+	keys, _ := brain.Keys("a:")
+	if len(keys) != 2 || keys[0] != "a:1" || keys[1] != "a:2" {
+		t.Errorf("Keys should have returned sorted keys with the prefix, got %v", keys)
+	}
+
+	brain.Delete("b")
+	if _, err := brain.Get("b"); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestMemoryBrainConformance(t *testing.T) {
+	braintest.Run(t, marvin.NewMemoryBrain())
+}
+
+func TestNamespace(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+
+	type score struct {
+		Points int `json:"points"`
+	}
+
+	karma := robot.Namespace("karma")
+	karma.Set("arthur", score{Points: 42})
+	karma.Set("ford", score{Points: 7})
+	robot.Namespace("other").Set("arthur", score{Points: 1})
+
+	var s score
+	if err := karma.Get("arthur", &s); err != nil || s.Points != 42 {
+		t.Errorf("Get should have decoded the stored value, got %+v, %v", s, err)
+	}
+
+	keys, _ := karma.Keys("")
+	if len(keys) != 2 || keys[0] != "arthur" || keys[1] != "ford" {
+		t.Errorf("Keys should have returned the namespace's keys without its prefix, got %v", keys)
+	}
+
+	if v, _ := robot.Brain.Get("karma:arthur"); string(v) != `{"points":42}` {
+		t.Errorf("Values should have been stored as JSON under the namespaced key, got %s", v)
+	}
+
+	karma.Delete("arthur")
+	if err := karma.Get("arthur", &s); err != marvin.ErrNotFound {
+		t.Errorf("Get should have returned ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestMemoryBrainTransaction(t *testing.T) {
+	brain := marvin.NewMemoryBrain()
+	brain.Set("a", []byte(`1`))
+
+	oops := errors.New("oops")
+	err := brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("b", []byte(`2`))
+		tx.Delete("a")
+		return oops
+	})
+
+	if err != oops {
+		t.Errorf("Transaction should have returned the error, got %v", err)
+	}
+
+	if keys, _ := brain.Keys(""); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("A failed transaction should have been rolled back, got %v", keys)
+	}
+
+	brain.Transaction(func(tx marvin.Brain) error {
+		tx.Set("b", []byte(`2`))
+		return tx.Delete("a")
+	})
+
+	if keys, _ := brain.Keys(""); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("A successful transaction should have been committed, got %v", keys)
+	}
+}
+
+func TestMemoryBrainCompareAndSwap(t *testing.T) {
+	brain := marvin.NewMemoryBrain()
+
+	if ok, _ := brain.CompareAndSwap("a", nil, []byte(`1`)); !ok {
+		t.Error("CompareAndSwap should have stored a new key")
+	}
+
+	if ok, _ := brain.CompareAndSwap("a", nil, []byte(`2`)); ok {
+		t.Error("CompareAndSwap should not have overwritten an existing key")
+	}
+
+	if ok, _ := brain.CompareAndSwap("a", []byte(`1`), []byte(`2`)); !ok {
+		t.Error("CompareAndSwap should have replaced a matching value")
+	}
+
+	if v, _ := brain.Get("a"); string(v) != `2` {
+		t.Errorf("Expected the swapped value, got %s", v)
+	}
+}
+
+func TestCopyBrain(t *testing.T) {
+	src := marvin.NewMemoryBrain()
+	src.Set("a", []byte(`1`))
+	src.Set("b", []byte(`2`))
+
+	dst := marvin.NewMemoryBrain()
+	n, err := marvin.CopyBrain(dst, src)
+	if err != nil || n != 2 {
+		t.Errorf("CopyBrain should have copied 2 keys, got %d, %v", n, err)
+	}
+
+	if v, _ := dst.Get("b"); string(v) != `2` {
+		t.Errorf("CopyBrain should have copied the values, got %s", v)
+	}
+}
@@ -0,0 +1,59 @@
+// Command marvin-migrate-brain copies the contents of a JSON file brain
+// into a bolt brain.
+//
+// Usage:
+//
+//	marvin-migrate-brain -from brain.json -to brain.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/brain/bolt"
+	"github.com/chielkunkels/marvin/brain/file"
+)
+
+func main() {
+	from := flag.String("from", "", "path of the JSON file brain to read")
+	to := flag.String("to", "", "path of the bolt brain to write")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := migrate(*from, *to); err != nil {
+		fmt.Fprintf(os.Stderr, "marvin-migrate-brain: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// migrate copies all keys from the file brain at from to the bolt brain at to.
+func migrate(from string, to string) error {
+	if _, err := os.Stat(from); err != nil {
+		return err
+	}
+
+	src, err := file.NewBrain(from)
+	if err != nil {
+		return err
+	}
+
+	dst, err := bolt.NewBrain(to)
+	if err != nil {
+		return err
+	}
+
+	n, err := marvin.CopyBrain(dst, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	fmt.Printf("Copied %d keys from %s to %s\n", n, from, to)
+	return dst.Close()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chielkunkels/marvin/brain/bolt"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marvin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "brain.json")
+	to := filepath.Join(dir, "brain.db")
+
+	if err := migrate(from, to); err == nil {
+		t.Error("migrate should have failed for a missing file brain")
+	}
+
+	if err := ioutil.WriteFile(from, []byte(`{"karma:arthur":42,"reminders:1":{"text":"towel"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(from, to); err != nil {
+		t.Fatalf("migrate should not have returned an error, got %s", err)
+	}
+
+	brain, err := bolt.NewBrain(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer brain.Close()
+
+	if keys, _ := brain.Keys(""); len(keys) != 2 {
+		t.Errorf("Expected both keys to be copied, got %v", keys)
+	}
+
+	if v, err := brain.Get("reminders:1"); err != nil || string(v) != `{"text":"towel"}` {
+		t.Errorf("Expected the value to be copied, got %s, %v", v, err)
+	}
+}
@@ -0,0 +1,485 @@
+package marvin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Argument types
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgFloat
+	ArgBool
+	ArgDuration
+)
+
+// Arg describes a positional argument of a command.
+type Arg struct {
+	// Default is used when the argument is not given. Arguments with
+	// a default are optional.
+	Default string
+
+	// HasDefault makes an empty Default a default too.
+	HasDefault bool
+
+	Name string
+
+	// Optional arguments may be omitted. Required arguments cannot
+	// follow optional ones.
+	Optional bool
+
+	// Rest makes the argument consume all remaining positional input,
+	// as it was typed. Only the last argument can be a rest argument.
+	Rest bool
+
+	Type ArgType
+
+	// Validate is called with the parsed value of the argument.
+	Validate func(interface{}) error
+}
+
+// hasDefault returns whether the argument has a default.
+func (a Arg) hasDefault() bool {
+	return a.HasDefault || a.Default != ""
+}
+
+// required returns whether the argument must be given.
+func (a Arg) required() bool {
+	return !a.Optional && !a.hasDefault()
+}
+
+// ArgType describes the type of a command argument or flag.
+type ArgType int
+
+// String returns the name of the type as shown in usage messages.
+func (t ArgType) String() string {
+	switch t {
+	case ArgInt:
+		return "int"
+	case ArgFloat:
+		return "float"
+	case ArgBool:
+		return "bool"
+	case ArgDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// parse converts the text to a value of the type.
+func (t ArgType) parse(text string) (interface{}, error) {
+	switch t {
+	case ArgInt:
+		return strconv.Atoi(text)
+	case ArgFloat:
+		return strconv.ParseFloat(text, 64)
+	case ArgBool:
+		return strconv.ParseBool(text)
+	case ArgDuration:
+		return time.ParseDuration(text)
+	default:
+		return text, nil
+	}
+}
+
+// Args holds the parsed arguments and flags of a command, by name.
+type Args map[string]interface{}
+
+// Bool returns the named argument as a bool.
+func (a Args) Bool(name string) bool {
+	v, _ := a[name].(bool)
+	return v
+}
+
+// Duration returns the named argument as a duration.
+func (a Args) Duration(name string) time.Duration {
+	v, _ := a[name].(time.Duration)
+	return v
+}
+
+// Float returns the named argument as a float.
+func (a Args) Float(name string) float64 {
+	v, _ := a[name].(float64)
+	return v
+}
+
+// Has returns whether the named argument was given or has a default.
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// Int returns the named argument as an int.
+func (a Args) Int(name string) int {
+	v, _ := a[name].(int)
+	return v
+}
+
+// String returns the named argument as a string.
+func (a Args) String(name string) string {
+	v, _ := a[name].(string)
+	return v
+}
+
+// Command describes a command directed at the robot, such as
+// `marvin deploy api production --force`.
+type Command struct {
+	Aliases     []string
+	Args        []Arg
+	Category    string
+	Description string
+	Examples    []string
+	Flags       []Flag
+	Handler     CommandHandler
+	Name        string
+}
+
+// CommandHandler describes the signature of a command handler.
+type CommandHandler func(*Request, Args) error
+
+// Usage returns a summary of the command's arguments and flags.
+func (c *Command) Usage() string {
+	parts := []string{c.Name}
+
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+
+		if arg.required() {
+			parts = append(parts, "<"+name+">")
+		} else {
+			parts = append(parts, "["+name+"]")
+		}
+	}
+
+	for _, flag := range c.Flags {
+		if flag.Type == ArgBool {
+			parts = append(parts, "[--"+flag.Name+"]")
+		} else {
+			parts = append(parts, "[--"+flag.Name+"="+flag.Type.String()+"]")
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// pattern returns the regular expression matching the command's name and aliases.
+func (c *Command) pattern() string {
+	names := []string{regexp.QuoteMeta(c.Name)}
+	for _, alias := range c.Aliases {
+		names = append(names, regexp.QuoteMeta(alias))
+	}
+
+	return `(?is)^(?:` + strings.Join(names, "|") + `)(?:\s+(.*))?$`
+}
+
+// validate checks that the command is well-formed.
+func (c *Command) validate() error {
+	if c.Name == "" {
+		return ErrCommandName
+	}
+
+	if c.Handler == nil {
+		return fmt.Errorf("command %s: no handler", c.Name)
+	}
+
+	seen := map[string]bool{}
+	optional := false
+	for i, arg := range c.Args {
+		if arg.Name == "" || seen[arg.Name] {
+			return fmt.Errorf("command %s: argument %d has an empty or duplicate name", c.Name, i)
+		}
+		seen[arg.Name] = true
+
+		if arg.required() && optional {
+			return fmt.Errorf("command %s: required argument %s follows an optional one", c.Name, arg.Name)
+		}
+		optional = optional || !arg.required()
+
+		if arg.Rest && i != len(c.Args)-1 {
+			return fmt.Errorf("command %s: rest argument %s is not the last argument", c.Name, arg.Name)
+		}
+
+		if arg.hasDefault() {
+			if _, err := arg.Type.parse(arg.Default); err != nil {
+				return fmt.Errorf("command %s: invalid default for %s: %s", c.Name, arg.Name, err)
+			}
+		}
+	}
+
+	for _, flag := range c.Flags {
+		if flag.Name == "" || seen[flag.Name] {
+			return fmt.Errorf("command %s: flag has an empty or duplicate name", c.Name)
+		}
+		seen[flag.Name] = true
+
+		if flag.hasDefault() {
+			if _, err := flag.Type.parse(flag.Default); err != nil {
+				return fmt.Errorf("command %s: invalid default for --%s: %s", c.Name, flag.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flag returns the flag with the given name.
+func (c *Command) flag(name string) (Flag, bool) {
+	for _, flag := range c.Flags {
+		if flag.Name == name {
+			return flag, true
+		}
+	}
+
+	return Flag{}, false
+}
+
+// parse parses the input following the command name into args.
+func (c *Command) parse(input string) (Args, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	args := Args{}
+	var positional []token
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if token.quoted || !strings.HasPrefix(token.text, "--") {
+			positional = append(positional, token)
+			continue
+		}
+
+		if token.text == "--" {
+			positional = append(positional, tokens[i+1:]...)
+			break
+		}
+
+		name := strings.TrimPrefix(token.text, "--")
+		value, hasValue := "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+
+		flag, ok := c.flag(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown flag --%s", name)
+		}
+
+		if !hasValue {
+			if flag.Type == ArgBool {
+				value = "true"
+			} else if i+1 < len(tokens) {
+				i++
+				value = tokens[i].text
+			} else {
+				return nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+		}
+
+		v, err := flag.Type.parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for --%s: expected %s", value, name, flag.Type)
+		}
+
+		if flag.Validate != nil {
+			if err := flag.Validate(v); err != nil {
+				return nil, fmt.Errorf("invalid value for --%s: %s", name, err)
+			}
+		}
+
+		args[name] = v
+	}
+
+	for _, flag := range c.Flags {
+		if _, ok := args[flag.Name]; !ok && flag.hasDefault() {
+			args[flag.Name], _ = flag.Type.parse(flag.Default)
+		}
+	}
+
+	for _, arg := range c.Args {
+		if len(positional) == 0 {
+			if arg.required() {
+				return nil, fmt.Errorf("missing argument %s", arg.Name)
+			}
+
+			if arg.hasDefault() {
+				args[arg.Name], _ = arg.Type.parse(arg.Default)
+			}
+
+			continue
+		}
+
+		text := positional[0].text
+		if arg.Rest {
+			text = raw(input, positional)
+			positional = nil
+		} else {
+			positional = positional[1:]
+		}
+
+		v, err := arg.Type.parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: expected %s", text, arg.Name, arg.Type)
+		}
+
+		if arg.Validate != nil {
+			if err := arg.Validate(v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %s", arg.Name, err)
+			}
+		}
+
+		args[arg.Name] = v
+	}
+
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", positional[0].text)
+	}
+
+	return args, nil
+}
+
+// raw returns the input the tokens were read from, as it was typed. Flags
+// in between the tokens are left out.
+func raw(input string, tokens []token) string {
+	text := ""
+	for i, t := range tokens {
+		if i > 0 {
+			between := input[tokens[i-1].end:t.start]
+			if strings.TrimSpace(between) != "" {
+				between = " "
+			}
+			text += between
+		}
+
+		text += input[t.start:t.end]
+	}
+
+	return text
+}
+
+// Flag describes a --flag of a command. Boolean flags take no value.
+type Flag struct {
+	Default string
+
+	// HasDefault makes an empty Default a default too.
+	HasDefault bool
+
+	Name     string
+	Type     ArgType
+	Validate func(interface{}) error
+}
+
+// hasDefault returns whether the flag has a default.
+func (f Flag) hasDefault() bool {
+	return f.HasDefault || f.Default != ""
+}
+
+// token describes a single word of command input, and where in the
+// input it starts and ends.
+type token struct {
+	end    int
+	quoted bool
+	start  int
+	text   string
+}
+
+// quotes maps opening quotes to their closing counterparts, including
+// the curly quotes chat clients like to substitute.
+var quotes = map[rune]rune{
+	'"':  '"',
+	'\'': '\'',
+	'“':  '”',
+	'‘':  '’',
+}
+
+// tokenize splits the input into words, honouring quotes and backslash escapes.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	var current []rune
+	var closing rune
+	inToken, quoted, escaped := false, false, false
+	start := 0
+
+	for i, c := range input {
+		if !inToken {
+			start = i
+		}
+
+		switch {
+		case escaped:
+			current = append(current, c)
+			escaped = false
+		case c == '\\' && closing != '\'':
+			escaped, inToken = true, true
+		case closing != 0:
+			if c == closing {
+				closing = 0
+			} else {
+				current = append(current, c)
+			}
+		case quotes[c] != 0:
+			closing = quotes[c]
+			inToken, quoted = true, true
+		case unicode.IsSpace(c):
+			if inToken {
+				tokens = append(tokens, token{end: i, quoted: quoted, start: start, text: string(current)})
+				current, inToken, quoted = nil, false, false
+			}
+		default:
+			current = append(current, c)
+			inToken = true
+		}
+	}
+
+	if escaped {
+		return nil, ErrTrailingEscape
+	}
+
+	if closing != 0 {
+		return nil, ErrUnterminatedQuote
+	}
+
+	if inToken {
+		tokens = append(tokens, token{end: len(input), quoted: quoted, start: start, text: string(current)})
+	}
+
+	return tokens, nil
+}
+
+// Command registers a command. The command responds to messages directed
+// at the robot starting with its name or one of its aliases. Input that
+// cannot be parsed is answered with the command's usage. The returned
+// listener can be used to disable or remove the command later on.
+func (r *Robot) Command(cmd *Command, options ...ListenerOption) (*Listener, error) {
+	if err := cmd.validate(); err != nil {
+		return nil, err
+	}
+
+	options = append([]ListenerOption{
+		func(l *Listener) { l.command = cmd },
+		WithCategory(cmd.Category),
+		WithDescription(cmd.Description),
+		WithExamples(cmd.Examples...),
+		WithName(cmd.Name),
+	}, options...)
+
+	return r.RespondHandler(cmd.pattern(), func(req *Request) error {
+		args, err := cmd.parse(req.Query[0])
+		if err != nil {
+			return req.Reply(fmt.Sprintf("%s\nUsage: %s", err, cmd.Usage()))
+		}
+
+		return cmd.Handler(req, args)
+	}, options...)
+}
@@ -0,0 +1,204 @@
+package marvin_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func newDeployCommand(args *marvin.Args) *marvin.Command {
+	return &marvin.Command{
+		Name:    "deploy",
+		Aliases: []string{"ship"},
+		Args: []marvin.Arg{
+			{Name: "service"},
+			{Name: "env", Default: "staging", Validate: func(v interface{}) error {
+				if v != "staging" && v != "production" {
+					return errors.New("must be staging or production")
+				}
+				return nil
+			}},
+			{Name: "note", Optional: true, Rest: true},
+		},
+		Flags: []marvin.Flag{
+			{Name: "force", Type: marvin.ArgBool},
+			{Name: "replicas", Type: marvin.ArgInt, Default: "1"},
+			{Name: "timeout", Type: marvin.ArgDuration},
+		},
+		Handler: func(r *marvin.Request, a marvin.Args) error {
+			*args = a
+			return nil
+		},
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		text  string
+		args  marvin.Args
+		reply string
+	}{
+		{
+			text: "marvin deploy api",
+			args: marvin.Args{"service": "api", "env": "staging", "replicas": 1},
+		},
+		{
+			text: "marvin ship api production --force --replicas=3 --timeout 5m",
+			args: marvin.Args{"service": "api", "env": "production", "force": true, "replicas": 3, "timeout": 5 * time.Minute},
+		},
+		{
+			text: `marvin DEPLOY "my api" staging fixes the "thing"`,
+			args: marvin.Args{"service": "my api", "env": "staging", "note": `fixes the "thing"`, "replicas": 1},
+		},
+		{
+			text: "marvin deploy api staging fixes  the --force thing",
+			args: marvin.Args{"service": "api", "env": "staging", "force": true, "note": "fixes  the thing", "replicas": 1},
+		},
+		{
+			text: "marvin deploy api staging -- --force",
+			args: marvin.Args{"service": "api", "env": "staging", "note": "--force", "replicas": 1},
+		},
+		{
+			text: "marvin deploy ‘my api’",
+			args: marvin.Args{"service": "my api", "env": "staging", "replicas": 1},
+		},
+		{
+			text:  "marvin deploy",
+			reply: "missing argument service\nUsage: deploy <service> [env] [note...] [--force] [--replicas=int] [--timeout=duration]",
+		},
+		{
+			text:  "marvin deploy api qa",
+			reply: "invalid value for env: must be staging or production",
+		},
+		{
+			text:  "marvin deploy api --replicas=many",
+			reply: `invalid value "many" for --replicas: expected int`,
+		},
+		{
+			text:  "marvin deploy api --verbose",
+			reply: "unknown flag --verbose",
+		},
+		{
+			text:  "marvin deploy api --timeout",
+			reply: "flag --timeout needs a value",
+		},
+		{
+			text:  `marvin deploy "api`,
+			reply: marvin.ErrUnterminatedQuote.Error(),
+		},
+		{
+			text:  `marvin deploy api\`,
+			reply: marvin.ErrTrailingEscape.Error(),
+		},
+		{
+			text: "marvin deployment api",
+		},
+	}
+
+	for _, test := range tests {
+		adapter := mock.NewAdapter()
+		robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+		robot.Open()
+
+		var args marvin.Args
+		if _, err := robot.Command(newDeployCommand(&args)); err != nil {
+			t.Fatalf("Command should not have returned an error, got %s", err)
+		}
+
+		adapter.PushMessage(newTestMessage("1234", test.text))
+		flush(t, robot, adapter, "1234")
+
+		if test.reply != "" {
+			if len(adapter.Replies) != 1 || !strings.HasPrefix(adapter.Replies[0], test.reply) {
+				t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, adapter.Replies)
+			}
+			continue
+		}
+
+		if len(adapter.Replies) != 0 {
+			t.Errorf("%q: expected no reply, got %q", test.text, adapter.Replies)
+		}
+
+		if len(args) != len(test.args) {
+			t.Errorf("%q: expected args %v, got %v", test.text, test.args, args)
+			continue
+		}
+
+		for name, v := range test.args {
+			if args[name] != v {
+				t.Errorf("%q: expected args %v, got %v", test.text, test.args, args)
+				break
+			}
+		}
+	}
+}
+
+func TestCommandValidation(t *testing.T) {
+	handler := func(*marvin.Request, marvin.Args) error { return nil }
+
+	tests := []*marvin.Command{
+		{Handler: handler},
+		{Name: "test"},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a"}, {Name: "a"}}},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a", Optional: true}, {Name: "b"}}},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a", Rest: true}, {Name: "b", Optional: true}}},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a", Type: marvin.ArgInt, Default: "one"}}},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a", Type: marvin.ArgInt, HasDefault: true}}},
+		{Name: "test", Handler: handler, Args: []marvin.Arg{{Name: "a"}}, Flags: []marvin.Flag{{Name: "a"}}},
+	}
+
+	for i, test := range tests {
+		adapter := mock.NewAdapter()
+		robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+		if _, err := robot.Command(test); err == nil {
+			t.Errorf("%d: Command should have returned an error", i)
+		}
+	}
+}
+
+func TestCommandEmptyDefault(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var args marvin.Args
+	_, err := robot.Command(&marvin.Command{
+		Name:  "greet",
+		Args:  []marvin.Arg{{Name: "name", HasDefault: true}},
+		Flags: []marvin.Flag{{Name: "greeting", HasDefault: true}},
+		Handler: func(r *marvin.Request, a marvin.Args) error {
+			args = a
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Command should not have returned an error, got %s", err)
+	}
+
+	adapter.PushMessage(newTestMessage("1234", "marvin greet"))
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 0 {
+		t.Errorf("Expected no reply, got %q", adapter.Replies)
+	}
+
+	if !args.Has("name") || args.String("name") != "" || !args.Has("greeting") || args.String("greeting") != "" {
+		t.Errorf("Expected empty defaults, got %v", args)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	args := marvin.Args{"b": true, "d": time.Second, "f": 1.5, "i": 2, "s": "str"}
+
+	if !args.Bool("b") || args.Duration("d") != time.Second || args.Float("f") != 1.5 || args.Int("i") != 2 || args.String("s") != "str" {
+		t.Error("Typed getters returned the wrong values")
+	}
+
+	if args.Int("s") != 0 || args.String("missing") != "" || args.Has("missing") || !args.Has("s") {
+		t.Error("Typed getters should return zero values for missing or mistyped arguments")
+	}
+}
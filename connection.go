@@ -0,0 +1,60 @@
+package marvin
+
+import "log"
+
+// ConnectionState describes the state of an adapter's connection to the chat.
+type ConnectionState int
+
+// Connection states.
+const (
+	Disconnected ConnectionState = iota
+	Connected
+	Reconnecting
+)
+
+// String returns the name of the state.
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	}
+
+	return "unknown"
+}
+
+// connectionStateChanged records a new state of the adapter's connection,
+// and calls the handlers registered through OnConnectionState.
+func (r *Robot) connectionStateChanged(state ConnectionState) {
+	log.Printf("marvin: %s", state)
+
+	r.mu.Lock()
+	r.connectionState = state
+	handlers := r.connectionHandlers
+	r.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+// ConnectionState returns the state of the adapter's connection. Adapters
+// that do not report the state of their connection are considered
+// connected once they are opened.
+func (r *Robot) ConnectionState() ConnectionState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.connectionState
+}
+
+// OnConnectionState adds a handler that is called whenever the adapter's
+// connection is established, lost or being reestablished.
+func (r *Robot) OnConnectionState(handler func(ConnectionState)) {
+	r.mu.Lock()
+	r.connectionHandlers = append(r.connectionHandlers, handler)
+	r.mu.Unlock()
+}
@@ -0,0 +1,168 @@
+package marvin
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// DefaultCancelWords are the answers that cancel a question.
+var DefaultCancelWords = []string{"cancel", "nevermind", "never mind", "stop"}
+
+// AskOption describes an option that can be passed when asking a question.
+type AskOption func(*askOptions)
+
+// askOptions holds the options of a question.
+type askOptions struct {
+	cancelWords []string
+	choices     []string
+	timeout     time.Duration
+}
+
+// WithAskTimeout sets how long to wait for an answer, overriding the
+// robot's AskTimeout.
+func WithAskTimeout(timeout time.Duration) AskOption {
+	return func(o *askOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithCancelWords sets the answers that cancel the question, overriding
+// DefaultCancelWords.
+func WithCancelWords(words ...string) AskOption {
+	return func(o *askOptions) {
+		o.cancelWords = words
+	}
+}
+
+// WithChoices restricts the answer to one of the given choices, compared
+// case-insensitively. Other answers are met with a reminder of the choices.
+func WithChoices(choices ...string) AskOption {
+	return func(o *askOptions) {
+		o.choices = choices
+	}
+}
+
+// conversationKey identifies whose answer a question is waiting for, and
+// in which thread.
+type conversationKey struct {
+	channel string
+	thread  string
+	user    string
+}
+
+// newConversationKey returns the key for the message's channel, thread and user.
+func newConversationKey(m *Message) (conversationKey, bool) {
+	if m.Channel == nil || m.User == nil {
+		return conversationKey{}, false
+	}
+
+	return conversationKey{channel: m.Channel.ID, thread: m.ThreadID, user: m.User.ID}, true
+}
+
+// capture hands the message to a question waiting for an answer from its
+// user in its channel, returning whether it did so.
+func (r *Robot) capture(m *Message) bool {
+	key, ok := newConversationKey(m)
+	if !ok {
+		return false
+	}
+
+	r.mu.RLock()
+	answers, ok := r.conversations[key]
+	r.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case answers <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Ask asks the user sending the request a question and waits for their next
+// message in the same channel and thread, which is not passed on to any listeners. It
+// returns ErrAskCancelled if the user answers with one of the cancel words,
+// ErrAskTimeout if they do not answer in time, or the context's error when
+// either ctx or the robot's context is done. While it waits, other messages,
+// including later ones in the same channel, are handled as usual. Answers
+// pass through the receive middleware like any other message, but as they
+// are not matched against listeners, they do not count towards the
+// robot's ChannelLimit and UserLimit.
+func (r *Request) Ask(ctx context.Context, question string, options ...AskOption) (string, error) {
+	o := &askOptions{cancelWords: DefaultCancelWords, timeout: r.robot.AskTimeout}
+	for _, option := range options {
+		option(o)
+	}
+
+	key, ok := newConversationKey(r.Message)
+	if !ok {
+		return "", ErrCannotAsk
+	}
+
+	answers := make(chan *Message, 1)
+
+	r.robot.mu.Lock()
+	if _, ok := r.robot.conversations[key]; ok {
+		r.robot.mu.Unlock()
+		return "", ErrConversationPending
+	}
+	r.robot.conversations[key] = answers
+	r.robot.mu.Unlock()
+
+	defer func() {
+		r.robot.mu.Lock()
+		delete(r.robot.conversations, key)
+		r.robot.mu.Unlock()
+	}()
+
+	if err := r.Reply(question); err != nil {
+		return "", err
+	}
+
+	// Waiting for an answer can take minutes, so don't hold on to a worker
+	// and the channel's queue meanwhile.
+	if r.Message.release != nil {
+		r.Message.release()
+	}
+
+	timeout := time.NewTimer(o.timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-r.robot.ctx.Done():
+			return "", r.robot.ctx.Err()
+		case <-timeout.C:
+			return "", ErrAskTimeout
+		case m := <-answers:
+			answer := strings.TrimSpace(r.robot.nameRegex.ReplaceAllString(m.Text, ""))
+
+			for _, word := range o.cancelWords {
+				if strings.EqualFold(answer, word) {
+					return "", ErrAskCancelled
+				}
+			}
+
+			if len(o.choices) == 0 {
+				return answer, nil
+			}
+
+			for _, choice := range o.choices {
+				if strings.EqualFold(answer, choice) {
+					return choice, nil
+				}
+			}
+
+			if err := r.Reply("Please answer with one of: " + strings.Join(o.choices, ", ") + "."); err != nil {
+				return "", err
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package marvin_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+// ask registers a command that asks a question and reports the outcome.
+// The returned asked channel is closed once the question has been asked.
+func ask(robot *marvin.Robot, adapter *mock.Adapter, options ...marvin.AskOption) (results chan string, asked chan struct{}) {
+	results = make(chan string, 1)
+	asked = make(chan struct{})
+
+	adapter.OnReply = func(m *marvin.Message, text string) {
+		if text == "Which environment?" {
+			close(asked)
+		}
+	}
+
+	robot.Respond("^deploy$", func(r *marvin.Request) {
+		answer, err := r.Ask(r.Context(), "Which environment?", options...)
+		if err != nil {
+			results <- "error: " + err.Error()
+			return
+		}
+
+		results <- answer
+	})
+
+	return results, asked
+}
+
+func waitForResult(t *testing.T, results <-chan string) string {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an answer")
+		return ""
+	}
+}
+
+func TestAsk(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	results, asked := ask(robot, adapter, marvin.WithChoices("staging", "production"))
+
+	var heard int32
+	robot.Hear(".", func(r *marvin.Request) {
+		atomic.AddInt32(&heard, 1)
+	})
+
+	other := newTestMessage("1234", "production")
+	other.User = &marvin.User{ID: "9999", Name: "someoneelse"}
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	<-asked
+
+	adapter.PushMessage(other)
+	adapter.PushMessage(newTestMessage("1234", "qa"))
+	adapter.PushMessage(newTestMessage("1234", "Production"))
+
+	if result := waitForResult(t, results); result != "production" {
+		t.Errorf("Expected answer production, got %q", result)
+	}
+
+	flush(t, robot, adapter, "1234")
+
+	// The command, the flush and the other user's message are heard.
+	if heard := atomic.LoadInt32(&heard); heard != 3 {
+		t.Errorf("Answers should not have been passed to listeners, %d messages were heard", heard)
+	}
+
+	if len(adapter.Replies) != 2 || adapter.Replies[0] != "Which environment?" || adapter.Replies[1] != "Please answer with one of: staging, production." {
+		t.Errorf("Unexpected replies %q", adapter.Replies)
+	}
+}
+
+func TestAskDoesNotHoldWorker(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Workers = 1
+	robot.Open()
+
+	results, asked := ask(robot, adapter)
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	<-asked
+
+	flush(t, robot, adapter, "5678")
+
+	pinged := make(chan struct{})
+	robot.Hear("^ping$", func(r *marvin.Request) {
+		close(pinged)
+	})
+
+	ping := newTestMessage("1234", "ping")
+	ping.User = &marvin.User{ID: "9999", Name: "someoneelse"}
+	adapter.PushMessage(ping)
+
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Fatal("Messages in the same channel should be handled while waiting for an answer")
+	}
+
+	adapter.PushMessage(newTestMessage("1234", "staging"))
+
+	if result := waitForResult(t, results); result != "staging" {
+		t.Errorf("Expected answer staging, got %q", result)
+	}
+}
+
+func TestAskReceiveMiddleware(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var received int32
+	robot.UseReceive(func(next marvin.MessageHandler) marvin.MessageHandler {
+		return func(m *marvin.Message) {
+			atomic.AddInt32(&received, 1)
+			if m.Text != "spam" {
+				next(m)
+			}
+		}
+	})
+
+	results, asked := ask(robot, adapter)
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	<-asked
+
+	adapter.PushMessage(newTestMessage("1234", "spam"))
+	adapter.PushMessage(newTestMessage("1234", "staging"))
+
+	if result := waitForResult(t, results); result != "staging" {
+		t.Errorf("Messages dropped by receive middleware should not be answers, got %q", result)
+	}
+
+	if received := atomic.LoadInt32(&received); received != 3 {
+		t.Errorf("Answers should have passed through the receive middleware, %d messages did", received)
+	}
+}
+
+func TestAskInThread(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	results := make(chan string, 1)
+	asked := make(chan struct{})
+	adapter.OnReplyInThread = func(m *marvin.Message, text string, broadcast bool) {
+		close(asked)
+	}
+
+	robot.Respond("^deploy$", func(r *marvin.Request) {
+		answer, _ := r.Ask(r.Context(), "Which environment?")
+		results <- answer
+	})
+
+	command := newTestMessage("1234", "marvin deploy")
+	command.ThreadID = "1"
+	adapter.PushMessage(command)
+	<-asked
+
+	elsewhere := newTestMessage("1234", "staging")
+	adapter.PushMessage(elsewhere)
+
+	answer := newTestMessage("1234", "production")
+	answer.ThreadID = "1"
+	adapter.PushMessage(answer)
+
+	if result := waitForResult(t, results); result != "production" {
+		t.Errorf("Expected the answer from the thread, got %q", result)
+	}
+}
+
+func TestAskCancel(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	results, asked := ask(robot, adapter)
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	<-asked
+	adapter.PushMessage(newTestMessage("1234", "marvin nevermind"))
+
+	if result := waitForResult(t, results); result != "error: "+marvin.ErrAskCancelled.Error() {
+		t.Errorf("Expected the question to be cancelled, got %q", result)
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	results, _ := ask(robot, adapter, marvin.WithAskTimeout(10*time.Millisecond))
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+
+	if result := waitForResult(t, results); result != "error: "+marvin.ErrAskTimeout.Error() {
+		t.Errorf("Expected the question to time out, got %q", result)
+	}
+}
+
+func TestAskClose(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	results, asked := ask(robot, adapter)
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	<-asked
+	robot.Close()
+
+	if result := waitForResult(t, results); result != "error: "+context.Canceled.Error() {
+		t.Errorf("Expected the question to be cancelled by closing the robot, got %q", result)
+	}
+}
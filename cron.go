@@ -0,0 +1,250 @@
+package marvin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule describes when a recurring job runs.
+type schedule interface {
+	// next returns the first time after t the job should run, or the
+	// zero time if it never will.
+	next(t time.Time) time.Time
+}
+
+// cronSchedule describes a schedule given as a cron expression. Each field
+// is a bit set of the values it matches.
+type cronSchedule struct {
+	dom    uint64
+	domAny bool
+	dow    uint64
+	dowAny bool
+	hour   uint64
+	minute uint64
+	month  uint64
+}
+
+// next returns the first minute after t matching the schedule, in t's location.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+
+	// A schedule like "0 0 30 2 *" never matches, so give up eventually.
+	limit := t.Year() + 5
+
+wrap:
+	for t.Year() <= limit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+
+		for !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay returns whether the day of t matches the schedule. As in cron,
+// a day matches either field if both the day of month and the day of week
+// are restricted.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// everySchedule describes a schedule running at a fixed interval.
+type everySchedule time.Duration
+
+// next returns t plus the interval.
+func (s everySchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronField describes the range and names of a field of a cron expression.
+type cronField struct {
+	max   int
+	min   int
+	name  string
+	names map[string]int
+}
+
+// cronFields are the fields of a cron expression, in order.
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// cronDescriptors are the shorthands that can be used instead of an expression.
+var cronDescriptors = map[string]string{
+	"@annually": "0 0 1 1 *",
+	"@daily":    "0 0 * * *",
+	"@hourly":   "0 * * * *",
+	"@midnight": "0 0 * * *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// parseSchedule parses a cron expression with five fields, such as
+// `30 9 * * mon-fri`, a descriptor such as `@daily`, or an interval such
+// as `@every 15m`. The expression may be preceded by `CRON_TZ=<zone>` to
+// evaluate it in that time zone, in which case the zone is returned too.
+func parseSchedule(spec string) (schedule, *time.Location, error) {
+	var loc *time.Location
+
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		var err error
+		loc, err = time.LoadLocation(fields[0][strings.Index(fields[0], "=")+1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) == 2 && fields[0] == "@every" {
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid schedule %q: invalid interval %s", spec, fields[1])
+		}
+
+		return everySchedule(d), loc, nil
+	}
+
+	if len(fields) == 1 {
+		if expr, ok := cronDescriptors[fields[0]]; ok {
+			fields = strings.Fields(expr)
+		}
+	}
+
+	if len(fields) != len(cronFields) {
+		return nil, nil, fmt.Errorf("invalid schedule %q: expected %d fields", spec, len(cronFields))
+	}
+
+	var bits [5]uint64
+	for i, field := range cronFields {
+		var err error
+		bits[i], err = field.parse(fields[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+	}
+
+	// Sunday may be given as either 0 or 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		domAny: fields[2] == "*" || fields[2] == "?",
+		month:  bits[3],
+		dow:    bits[4],
+		dowAny: fields[4] == "*" || fields[4] == "?",
+	}, loc, nil
+}
+
+// parse parses a comma-separated list of values, ranges and steps, such
+// as `1,15-20,*/5`, into a bit set.
+func (f cronField) parse(text string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(strings.ToLower(text), ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", f.name, part)
+			}
+			part = part[:i]
+		}
+
+		min, max := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if min, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if max, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			if min > max {
+				return 0, fmt.Errorf("invalid range in %s %q", f.name, part)
+			}
+		default:
+			var err error
+			if min, err = f.value(part); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				max = min
+			}
+		}
+
+		for v := min; v <= max; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a single number or name of the field.
+func (f cronField) value(text string) (int, error) {
+	if v, ok := f.names[text]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q", f.name, text)
+	}
+
+	return v, nil
+}
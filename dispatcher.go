@@ -0,0 +1,115 @@
+package marvin
+
+import (
+	"sync"
+)
+
+// dispatcher runs jobs on a bounded pool of workers. Every key has its own
+// queue, whose jobs run one at a time in queue order, so a slow job only
+// holds up the jobs queued behind it with the same key.
+type dispatcher struct {
+	mu        sync.Mutex
+	queueSize int
+	queues    map[string][]func(release func())
+	slots     chan struct{}
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// newDispatcher creates a new dispatcher that runs at most the given number
+// of jobs at once, and queues at most queueSize jobs per key.
+func newDispatcher(workers int, queueSize int) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	return &dispatcher{
+		queueSize: queueSize,
+		queues:    map[string][]func(release func()){},
+		slots:     make(chan struct{}, workers),
+	}
+}
+
+// dispatch queues a job behind the other jobs with the given key. It never
+// blocks; it returns false, dropping the job, if the key's queue is full or
+// the dispatcher has been stopped. A job that is going to wait for a long
+// time calls release, which gives up its slot in the pool and lets the next
+// job with its key start.
+func (d *dispatcher) dispatch(key string, job func(release func())) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return false
+	}
+
+	queue, running := d.queues[key]
+	if len(queue) >= d.queueSize {
+		return false
+	}
+
+	d.queues[key] = append(queue, job)
+	if !running {
+		d.wg.Add(1)
+		go d.run(key)
+	}
+
+	return true
+}
+
+// run runs the jobs queued with the given key, one at a time, until its
+// queue is empty or a job releases it. Every job waits for a free slot in
+// the pool first.
+func (d *dispatcher) run(key string) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+
+		job := queue[0]
+		queue[0] = nil
+		d.queues[key] = queue[1:]
+		d.mu.Unlock()
+
+		var once sync.Once
+		released := false
+
+		d.slots <- struct{}{}
+		job(func() {
+			once.Do(func() {
+				released = true
+				<-d.slots
+
+				d.wg.Add(1)
+				go d.run(key)
+			})
+		})
+		once.Do(func() { <-d.slots })
+
+		if released {
+			return
+		}
+	}
+}
+
+// stop stops accepting jobs; jobs already queued still run.
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	d.mu.Unlock()
+}
+
+// wait blocks until all queued jobs have run.
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,33 @@
+package marvin
+
+// Marvin errors
+const (
+	ErrAskCancelled        = Error("question was cancelled")
+	ErrAskTimeout          = Error("timed out waiting for an answer")
+	ErrCannotAsk           = Error("cannot ask without a channel and user")
+	ErrChannelNotAllowed   = Error("listener not allowed in this channel")
+	ErrCommandName         = Error("command has no name")
+	ErrConversationPending = Error("already waiting for an answer from this user")
+	ErrInvalidValue        = Error("value is not a JSON document")
+	ErrNotFound            = Error("not found")
+	ErrPermissionDenied    = Error("permission denied")
+	ErrNoDirectMessages    = Error("adapter cannot send direct messages")
+	ErrNoDirectory         = Error("adapter cannot look up users or channels")
+	ErrOutboxFull          = Error("too many messages queued for this channel")
+	ErrRobotClosed         = Error("robot has been closed")
+	ErrRoleNotGranted      = Error("user does not have role")
+	ErrShutdownTimeout     = Error("timed out waiting for listeners to finish")
+	ErrTrailingEscape      = Error("backslash at the end of the input")
+	ErrUnknownChannel      = Error("unknown channel")
+	ErrUnknownRole         = Error("unknown role")
+	ErrUnknownUser         = Error("unknown user")
+	ErrUnterminatedQuote   = Error("unterminated quote")
+)
+
+// Error describes a Marvin error
+type Error string
+
+// Error returns the error
+func (e Error) Error() string {
+	return string(e)
+}
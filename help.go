@@ -0,0 +1,167 @@
+package marvin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultCategory is the category of listeners that do not set one.
+const defaultCategory = "General"
+
+// helpCommand returns the built-in help command.
+func helpCommand(r *Robot) *Command {
+	return &Command{
+		Name:        "help",
+		Args:        []Arg{{Name: "command", Optional: true}},
+		Description: "Lists what I can do, or explains a single command.",
+		Examples:    []string{"help", "help deploy", "help --page 2"},
+		Flags:       []Flag{{Name: "page", Type: ArgInt, Default: "1"}},
+		Handler:     r.help,
+	}
+}
+
+// help replies with either the details of a single command or the list of
+// everything the user is permitted to run. The list is paginated in DMs.
+func (r *Robot) help(req *Request, args Args) error {
+	listeners := r.helpListeners(req)
+
+	if args.Has("command") {
+		name := strings.ToLower(args.String("command"))
+		for _, l := range listeners {
+			if l.knownAs(name) {
+				return req.Reply(helpDetails(l))
+			}
+		}
+
+		return req.Reply(fmt.Sprintf("I don't know a command called %q. Say `help` to see what I can do.", name))
+	}
+
+	if len(listeners) == 0 {
+		return req.Reply("I can't do anything for you yet.")
+	}
+
+	page, pages := 1, 1
+	if req.Message.Channel != nil && req.Message.Channel.IsDM && r.HelpPageSize > 0 {
+		pages = (len(listeners) + r.HelpPageSize - 1) / r.HelpPageSize
+
+		page = args.Int("page")
+		if page < 1 {
+			page = 1
+		} else if page > pages {
+			page = pages
+		}
+
+		end := page * r.HelpPageSize
+		if end > len(listeners) {
+			end = len(listeners)
+		}
+		listeners = listeners[(page-1)*r.HelpPageSize : end]
+	}
+
+	var lines []string
+	category := ""
+	for i, l := range listeners {
+		if c := l.helpCategory(); i == 0 || c != category {
+			category = c
+			lines = append(lines, "*"+category+"*")
+		}
+
+		// Listeners with only a description have no usage to show.
+		var parts []string
+		if usage := l.usage(); usage != "" {
+			parts = append(parts, "`"+usage+"`")
+		}
+		if l.description != "" {
+			parts = append(parts, l.description)
+		}
+		lines = append(lines, strings.Join(parts, " - "))
+	}
+
+	if pages > 1 {
+		footer := fmt.Sprintf("Page %d of %d.", page, pages)
+		if page < pages {
+			footer += fmt.Sprintf(" Say `help --page %d` for more.", page+1)
+		}
+		lines = append(lines, footer)
+	}
+
+	return req.Reply(strings.Join(lines, "\n"))
+}
+
+// helpListeners returns the documented listeners the request's user is
+// permitted to run, sorted by category and name.
+func (r *Robot) helpListeners(req *Request) []*Listener {
+	r.mu.RLock()
+	all := r.listeners
+	r.mu.RUnlock()
+
+	var listeners []*Listener
+	for _, l := range all {
+		if l.documented() && l.enabledIn(req.Message.Channel) && l.allows(req) && r.authorize(l, req) == nil {
+			listeners = append(listeners, l)
+		}
+	}
+
+	sort.SliceStable(listeners, func(i, j int) bool {
+		ci, cj := listeners[i].helpCategory(), listeners[j].helpCategory()
+		if ci != cj {
+			return ci < cj
+		}
+
+		return listeners[i].name < listeners[j].name
+	})
+
+	return listeners
+}
+
+// helpDetails describes a single listener.
+func helpDetails(l *Listener) string {
+	var lines []string
+	if usage := l.usage(); usage != "" {
+		lines = append(lines, "`"+usage+"`")
+	}
+
+	if l.description != "" {
+		lines = append(lines, l.description)
+	}
+
+	if l.command != nil && len(l.command.Aliases) > 0 {
+		lines = append(lines, "Aliases: "+strings.Join(l.command.Aliases, ", "))
+	}
+
+	if len(l.examples) > 0 {
+		lines = append(lines, "Examples:")
+		for _, example := range l.examples {
+			lines = append(lines, "> "+example)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// helpCategory returns the category the listener is listed under.
+func (l *Listener) helpCategory() string {
+	if l.category == "" {
+		return defaultCategory
+	}
+
+	return l.category
+}
+
+// knownAs returns whether the listener goes by the given lowercase name.
+func (l *Listener) knownAs(name string) bool {
+	if strings.ToLower(l.name) == name {
+		return true
+	}
+
+	if l.command != nil {
+		for _, alias := range l.command.Aliases {
+			if strings.ToLower(alias) == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+package marvin_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func newHelpRobot() (*marvin.Robot, *mock.Adapter) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	handler := func(*marvin.Request, marvin.Args) error { return nil }
+
+	robot.Command(&marvin.Command{
+		Name:        "deploy",
+		Aliases:     []string{"ship"},
+		Args:        []marvin.Arg{{Name: "service"}},
+		Category:    "Ops",
+		Description: "Deploys a service.",
+		Examples:    []string{"deploy api"},
+		Handler:     handler,
+	})
+
+	robot.Command(&marvin.Command{
+		Name:        "nuke",
+		Category:    "Ops",
+		Description: "Destroys everything.",
+		Handler:     handler,
+	}, marvin.WithGuard(func(r *marvin.Request) error {
+		if r.Message.User.Name != "admin" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}))
+
+	robot.Hear("coffee", func(*marvin.Request) {},
+		marvin.WithName("coffee"), marvin.WithDescription("Mentions coffee."), marvin.WithCategory("Fun"))
+
+	robot.Hear("undocumented", func(*marvin.Request) {})
+
+	return robot, adapter
+}
+
+func TestHelp(t *testing.T) {
+	robot, adapter := newHelpRobot()
+
+	adapter.PushMessage(newTestMessage("1234", "marvin help"))
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 1 {
+		t.Fatalf("Expected a single reply, got %q", adapter.Replies)
+	}
+
+	reply := adapter.Replies[0]
+	for _, s := range []string{"*Fun*", "`coffee` - Mentions coffee.", "*Ops*", "`deploy <service>` - Deploys a service.", "`help [command] [--page=int]`"} {
+		if !strings.Contains(reply, s) {
+			t.Errorf("Help should have contained %q, got %q", s, reply)
+		}
+	}
+
+	for _, s := range []string{"nuke", "undocumented", "Page"} {
+		if strings.Contains(reply, s) {
+			t.Errorf("Help should not have contained %q, got %q", s, reply)
+		}
+	}
+}
+
+func TestHelpDescriptionOnly(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	robot.Hear("tea", func(*marvin.Request) {}, marvin.WithDescription("Mentions tea."))
+
+	adapter.PushMessage(newTestMessage("1234", "marvin help"))
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 1 {
+		t.Fatalf("Expected a single reply, got %q", adapter.Replies)
+	}
+
+	reply := adapter.Replies[0]
+	if !strings.Contains(reply, "\nMentions tea.") {
+		t.Errorf("Help should have listed the description on its own, got %q", reply)
+	}
+
+	if strings.Contains(reply, "``") {
+		t.Errorf("Help should not have contained an empty usage, got %q", reply)
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	robot, adapter := newHelpRobot()
+
+	adapter.PushMessage(newTestMessage("1234", "marvin help ship"))
+	adapter.PushMessage(newTestMessage("1234", "marvin help nuke"))
+	flush(t, robot, adapter, "1234")
+
+	if len(adapter.Replies) != 2 {
+		t.Fatalf("Expected two replies, got %q", adapter.Replies)
+	}
+
+	expected := "`deploy <service>`\nDeploys a service.\nAliases: ship\nExamples:\n> deploy api"
+	if adapter.Replies[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, adapter.Replies[0])
+	}
+
+	if !strings.HasPrefix(adapter.Replies[1], "I don't know a command called \"nuke\"") {
+		t.Errorf("Help should not have revealed a forbidden command, got %q", adapter.Replies[1])
+	}
+}
+
+func TestHelpPagination(t *testing.T) {
+	robot, adapter := newHelpRobot()
+	robot.HelpPageSize = 2
+
+	dm := func(text string) *marvin.Message {
+		m := newTestMessage("D1234", text)
+		m.Channel.IsDM = true
+		m.User.Name = "admin"
+		return m
+	}
+
+	adapter.PushMessage(dm("marvin help"))
+	adapter.PushMessage(dm("marvin help --page 3"))
+	flush(t, robot, adapter, "D1234")
+
+	if len(adapter.Replies) != 2 {
+		t.Fatalf("Expected two replies, got %q", adapter.Replies)
+	}
+
+	if !strings.HasSuffix(adapter.Replies[0], "Page 1 of 3. Say `help --page 2` for more.") || strings.Count(adapter.Replies[0], "`") != 6 {
+		t.Errorf("Expected the first page of help, got %q", adapter.Replies[0])
+	}
+
+	if !strings.HasSuffix(adapter.Replies[1], "Page 3 of 3.") || !strings.Contains(adapter.Replies[1], "nuke") {
+		t.Errorf("Expected the last page of help, got %q", adapter.Replies[1])
+	}
+}
@@ -0,0 +1,194 @@
+package marvin
+
+import "time"
+
+// allowedIn returns whether the listener's channel allow and deny lists
+// permit it to run in the given channel.
+func (l *Listener) allowedIn(channel *Channel) bool {
+	if channel == nil {
+		return len(l.allowChannels) == 0
+	}
+
+	if l.denyChannels[channel.ID] || l.denyChannels[channel.Name] {
+		return false
+	}
+
+	return len(l.allowChannels) == 0 || l.allowChannels[channel.ID] || l.allowChannels[channel.Name]
+}
+
+// allows returns whether all of the listener's guards pass for the request.
+func (l *Listener) allows(req *Request) bool {
+	for _, guard := range l.guards {
+		if guard(req) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Disable stops the listener from matching any messages until it is enabled again.
+func (l *Listener) Disable() {
+	l.mu.Lock()
+	l.disabled = true
+	l.mu.Unlock()
+}
+
+// DisableIn stops the listener from matching messages in the channel with
+// the given ID until it is enabled in that channel again.
+func (l *Listener) DisableIn(channelID string) {
+	l.mu.Lock()
+	l.disabledIn[channelID] = true
+	l.mu.Unlock()
+}
+
+// documented returns whether the listener should be listed in the help.
+func (l *Listener) documented() bool {
+	return l.name != "" || l.description != ""
+}
+
+// Enable undoes Disable. It does not affect channels the listener was
+// disabled in through DisableIn.
+func (l *Listener) Enable() {
+	l.mu.Lock()
+	l.disabled = false
+	l.mu.Unlock()
+}
+
+// EnableIn undoes DisableIn for the channel with the given ID.
+func (l *Listener) EnableIn(channelID string) {
+	l.mu.Lock()
+	delete(l.disabledIn, channelID)
+	l.mu.Unlock()
+}
+
+// enabledIn returns whether the listener is enabled in the given channel.
+func (l *Listener) enabledIn(channel *Channel) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.disabled {
+		return false
+	}
+
+	return channel == nil || !l.disabledIn[channel.ID]
+}
+
+// Remove removes the listener from its robot. Messages that are being
+// handled while it is removed may still reach it.
+func (l *Listener) Remove() {
+	r := l.robot
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listeners := make([]*Listener, 0, len(r.listeners))
+	for _, listener := range r.listeners {
+		if listener != l {
+			listeners = append(listeners, listener)
+		}
+	}
+
+	r.listeners = listeners
+}
+
+// usage returns how to invoke the listener, as shown in the help.
+func (l *Listener) usage() string {
+	if l.command != nil {
+		return l.command.Usage()
+	}
+
+	return l.name
+}
+
+// AllowChannels restricts the listener to the given channels, by ID or name.
+func AllowChannels(channels ...string) ListenerOption {
+	return func(l *Listener) {
+		if l.allowChannels == nil {
+			l.allowChannels = map[string]bool{}
+		}
+
+		for _, channel := range channels {
+			l.allowChannels[channel] = true
+		}
+	}
+}
+
+// DenyChannels stops the listener from running in the given channels, by ID or name.
+func DenyChannels(channels ...string) ListenerOption {
+	return func(l *Listener) {
+		if l.denyChannels == nil {
+			l.denyChannels = map[string]bool{}
+		}
+
+		for _, channel := range channels {
+			l.denyChannels[channel] = true
+		}
+	}
+}
+
+// Exclusive makes the listener stop any further listeners from running
+// when it matches a message.
+func Exclusive() ListenerOption {
+	return func(l *Listener) {
+		l.exclusive = true
+	}
+}
+
+// RequirePermission restricts the listener to users who have the given
+// permission through one of their roles.
+func RequirePermission(permission string) ListenerOption {
+	return func(l *Listener) {
+		l.permission = permission
+	}
+}
+
+// WithCategory sets the category the listener is listed under in the help.
+func WithCategory(category string) ListenerOption {
+	return func(l *Listener) {
+		l.category = category
+	}
+}
+
+// WithDescription sets the description of the listener shown in the help.
+func WithDescription(description string) ListenerOption {
+	return func(l *Listener) {
+		l.description = description
+	}
+}
+
+// WithExamples sets usage examples of the listener shown in the help.
+func WithExamples(examples ...string) ListenerOption {
+	return func(l *Listener) {
+		l.examples = examples
+	}
+}
+
+// WithGuard adds a guard to the listener.
+func WithGuard(guard Guard) ListenerOption {
+	return func(l *Listener) {
+		l.guards = append(l.guards, guard)
+	}
+}
+
+// WithName sets the name the listener is known by in the help.
+func WithName(name string) ListenerOption {
+	return func(l *Listener) {
+		l.name = name
+	}
+}
+
+// WithPriority sets the priority of the listener. Listeners with a higher
+// priority are matched first; the default priority is 0.
+func WithPriority(priority int) ListenerOption {
+	return func(l *Listener) {
+		l.priority = priority
+	}
+}
+
+// WithTimeout sets a timeout on the context of requests handled by the listener.
+func WithTimeout(timeout time.Duration) ListenerOption {
+	return func(l *Listener) {
+		l.timeout = timeout
+	}
+}
@@ -1,6 +1,15 @@
 package marvin
 
-import "regexp"
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pressly/chi"
+)
 
 // Adapter describes the interface an adapter should implement.
 type Adapter interface {
@@ -18,21 +27,131 @@ type Channel struct {
 	IsDM bool
 }
 
+// ConnectionNotifier describes an adapter that reports changes to the
+// state of its connection.
+type ConnectionNotifier interface {
+	OnConnectionState(func(ConnectionState))
+}
+
+// Directory describes an adapter that can look up users and channels by name.
+type Directory interface {
+	ChannelByName(string) (*Channel, bool)
+	UserByName(string) (*User, bool)
+}
+
+// DirectMessenger describes an adapter that can send direct messages to users.
+type DirectMessenger interface {
+	SendDirectMessage(string, string) error
+}
+
+// ErrorHandler describes the signature of a function handling errors returned by listeners.
+type ErrorHandler func(*Request, error)
+
+// DefaultErrorHandler logs the error, including the stack trace of panics.
+func DefaultErrorHandler(req *Request, err error) {
+	if perr, ok := err.(*PanicError); ok {
+		log.Printf("marvin: error handling %q: %s\n%s", req.Message.Text, perr, perr.Stack)
+		return
+	}
+
+	log.Printf("marvin: error handling %q: %s", req.Message.Text, err)
+}
+
+// Guard describes a check that must pass for a listener to run. Listeners
+// whose guards fail are skipped, and hidden from the help.
+type Guard func(*Request) error
+
 // Listener describes a listener.
 type Listener struct {
-	callback ListenerCallback
-	direct   bool
-	regex    *regexp.Regexp
+	allowChannels map[string]bool
+	category      string
+	command       *Command
+	denyChannels  map[string]bool
+	description   string
+	direct        bool
+	disabled      bool
+	disabledIn    map[string]bool
+	examples      []string
+	exclusive     bool
+	guards        []Guard
+	handler       ListenerHandler
+	limiter       *limiter
+	mu            sync.RWMutex
+	name          string
+	permission    string
+	priority      int
+	regex         *regexp.Regexp
+	robot         *Robot
+	timeout       time.Duration
 }
 
 // ListenerCallback describes the signature of a listener callback.
 type ListenerCallback func(*Request)
 
+// handler turns the callback into a handler that never returns an error.
+func (c ListenerCallback) handler() ListenerHandler {
+	return func(req *Request) error {
+		c(req)
+		return nil
+	}
+}
+
+// ListenerHandler describes the signature of a listener callback that can return an error.
+type ListenerHandler func(*Request) error
+
+// ListenerMiddleware describes middleware wrapping the handler of a matched listener.
+type ListenerMiddleware func(ListenerHandler) ListenerHandler
+
+// ListenerOption describes an option that can be passed when creating a listener.
+type ListenerOption func(*Listener)
+
 // Message describes a message.
 type Message struct {
 	Channel *Channel
 	User    *User
 	Text    string
+
+	// ID identifies the message within its channel, and ThreadID the thread
+	// it was posted in, for adapters that have them. Messages that were not
+	// posted in a thread have no ThreadID.
+	ID       string
+	ThreadID string
+
+	// Payload is the raw payload of messages that stand for something other
+	// than text, such as a button being clicked, for adapters that have them.
+	Payload json.RawMessage
+
+	// release gives up the worker handling the message, for listeners that
+	// wait for a long time.
+	release func()
+}
+
+// MessageHandler describes the signature of a function handling an incoming message.
+type MessageHandler func(*Message)
+
+// Mounter describes an adapter that serves HTTP endpoints, which the robot
+// mounts on its Router when it is opened.
+type Mounter interface {
+	Mount(chi.Router)
+}
+
+// PanicError describes a panic that occurred in a listener.
+type PanicError struct {
+	Stack []byte
+	Value interface{}
+}
+
+// Error returns the error
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// ReceiveMiddleware describes middleware that runs for every incoming message.
+type ReceiveMiddleware func(MessageHandler) MessageHandler
+
+// Threader describes an adapter that can reply to messages in threads.
+type Threader interface {
+	ReplyInThread(*Message, string, bool) error
 }
 
 // User describes a user.
@@ -7,10 +7,33 @@ type Adapter struct {
 	err      error
 	messages chan<- *marvin.Message
 
-	CloseCalled bool
-	OpenCalled  bool
-	ReplyCalled bool
-	SendCalled  bool
+	CloseCalled             bool
+	OpenCalled              bool
+	ReplyCalled             bool
+	ReplyInThreadCalled     bool
+	SendCalled              bool
+	SendDirectMessageCalled bool
+	SendMessageCalled       bool
+
+	Channels []*marvin.Channel
+	Replies  []string
+	Sent     []string
+	Users    []*marvin.User
+
+	// OnReply, if set, is called for every reply
+	OnReply func(m *marvin.Message, text string)
+
+	// OnReplyInThread, if set, is called for every reply in a thread
+	OnReplyInThread func(m *marvin.Message, text string, broadcast bool)
+
+	// OnSend, if set, is called for every message sent to a message's channel
+	OnSend func(m *marvin.Message, text string)
+
+	// OnSendDirectMessage, if set, is called for every direct message
+	OnSendDirectMessage func(user string, text string)
+
+	// OnSendMessage, if set, is called for every message sent to a channel by name
+	OnSendMessage func(channel string, text string)
 }
 
 // NewAdapter returns a new mock adapter
@@ -18,6 +41,17 @@ func NewAdapter() *Adapter {
 	return &Adapter{}
 }
 
+// ChannelByName looks up one of the adapter's channels by name
+func (a *Adapter) ChannelByName(name string) (*marvin.Channel, bool) {
+	for _, c := range a.Channels {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
 // Close mocks an adapter closing the connection
 func (a *Adapter) Close() error {
 	a.CloseCalled = true
@@ -39,12 +73,58 @@ func (a *Adapter) PushMessage(m *marvin.Message) {
 // Reply sends a reply directed at the user sending the request
 func (a *Adapter) Reply(m *marvin.Message, text string) error {
 	a.ReplyCalled = true
+	a.Replies = append(a.Replies, text)
+
+	if a.OnReply != nil {
+		a.OnReply(m, text)
+	}
+
+	return a.err
+}
+
+// ReplyInThread sends a reply in the thread of the message
+func (a *Adapter) ReplyInThread(m *marvin.Message, text string, broadcast bool) error {
+	a.ReplyInThreadCalled = true
+	a.Replies = append(a.Replies, text)
+
+	if a.OnReplyInThread != nil {
+		a.OnReplyInThread(m, text, broadcast)
+	}
+
 	return a.err
 }
 
 // Send sends a message in the channel the request originated from
 func (a *Adapter) Send(m *marvin.Message, text string) error {
 	a.SendCalled = true
+	a.Sent = append(a.Sent, text)
+
+	if a.OnSend != nil {
+		a.OnSend(m, text)
+	}
+
+	return a.err
+}
+
+// SendDirectMessage sends a direct message to a user by name
+func (a *Adapter) SendDirectMessage(user string, text string) error {
+	a.SendDirectMessageCalled = true
+
+	if a.OnSendDirectMessage != nil {
+		a.OnSendDirectMessage(user, text)
+	}
+
+	return a.err
+}
+
+// SendMessage sends a message to a channel by name
+func (a *Adapter) SendMessage(channel string, text string) error {
+	a.SendMessageCalled = true
+
+	if a.OnSendMessage != nil {
+		a.OnSendMessage(channel, text)
+	}
+
 	return a.err
 }
 
@@ -52,3 +132,14 @@ func (a *Adapter) Send(m *marvin.Message, text string) error {
 func (a *Adapter) SetError(err error) {
 	a.err = err
 }
+
+// UserByName looks up one of the adapter's users by name
+func (a *Adapter) UserByName(name string) (*marvin.User, bool) {
+	for _, u := range a.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,336 @@
+package reminders
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse errors
+const (
+	ErrNoTarget = Error("who should I remind? Say `remind me ...`, `remind #channel ...` or `remind @someone ...`")
+	ErrNoText   = Error("what should I remind about? Say `... to <something>`")
+	ErrNoWhen   = Error("when should I remind? Try `in 2h`, `tomorrow at 9`, `on friday` or `every monday at 10`")
+	ErrPast     = Error("that time has already passed")
+)
+
+// Error describes a reminders error
+type Error string
+
+// Error returns the error
+func (e Error) Error() string {
+	return string(e)
+}
+
+// defaultHour is the hour reminders for a day without a time are delivered.
+const defaultHour = 9
+
+// timeRegexp matches times of day, such as `9`, `9:30`, `9pm` and `21:00`.
+var timeRegexp = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+// units maps the units accepted in relative times to their duration.
+var units = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// weekdays maps the names of the days of the week to their number.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// whenWords are the words a description of when to remind can start with.
+var whenWords = map[string]bool{
+	"at": true, "every": true, "in": true, "next": true, "on": true, "today": true, "tomorrow": true,
+}
+
+// Parse parses the text following `remind`, such as `me tomorrow at 9 to
+// review the PR` or `#ops to rotate the on-call every monday at 10`, into
+// a reminder. Times are interpreted relative to now, in its location.
+func Parse(text string, now time.Time) (*Reminder, error) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return nil, ErrNoTarget
+	}
+
+	target := strings.ToLower(words[0])
+	if target != "me" && !strings.HasPrefix(target, "#") && !strings.HasPrefix(target, "@") {
+		return nil, ErrNoTarget
+	}
+
+	reminder := &Reminder{Target: words[0]}
+	if target == "me" {
+		reminder.Target = "me"
+	}
+
+	words = words[1:]
+
+	// Either `<when> to <text>`...
+	for i, word := range words {
+		if strings.ToLower(word) != "to" || i == 0 {
+			continue
+		}
+
+		if err := reminder.parseWhen(words[:i], now); err == nil {
+			reminder.Text = strings.Join(words[i+1:], " ")
+			return reminder.validate()
+		} else if err == ErrPast {
+			return nil, err
+		}
+	}
+
+	// ...or `to <text> <when>`.
+	if len(words) == 0 || strings.ToLower(words[0]) != "to" {
+		for _, word := range words {
+			if strings.ToLower(word) == "to" {
+				return nil, ErrNoWhen
+			}
+		}
+
+		return nil, ErrNoText
+	}
+
+	words = words[1:]
+	for i := 1; i < len(words); i++ {
+		word := strings.ToLower(words[i])
+		if _, ok := weekdays[word]; !ok && !whenWords[word] {
+			continue
+		}
+
+		if err := reminder.parseWhen(words[i:], now); err == nil {
+			reminder.Text = strings.Join(words[:i], " ")
+			return reminder.validate()
+		} else if err == ErrPast {
+			return nil, err
+		}
+	}
+
+	return nil, ErrNoWhen
+}
+
+// validate checks that the reminder has something to remind about.
+func (r *Reminder) validate() (*Reminder, error) {
+	if strings.TrimSpace(r.Text) == "" {
+		return nil, ErrNoText
+	}
+
+	return r, nil
+}
+
+// parseWhen parses a description of when to remind, such as `in 2 hours`,
+// `tomorrow at 9`, `on friday at 16:30` or `every weekday at 9:15`, setting
+// either the time of the reminder or its schedule.
+func (r *Reminder) parseWhen(words []string, now time.Time) error {
+	if len(words) == 0 {
+		return ErrNoWhen
+	}
+
+	lower := make([]string, len(words))
+	for i, word := range words {
+		lower[i] = strings.ToLower(word)
+	}
+
+	switch lower[0] {
+	case "in":
+		d, err := parseDuration(lower[1:])
+		if err != nil {
+			return err
+		}
+
+		r.At = now.Add(d)
+		return nil
+	case "every":
+		return r.parseEvery(lower[1:], words)
+	}
+
+	day, rest, err := parseDay(lower, now)
+	if err != nil {
+		return err
+	}
+
+	hour, minute := defaultHour, 0
+	if len(rest) > 0 {
+		if rest[0] != "at" || len(rest) == 1 {
+			return ErrNoWhen
+		}
+
+		if hour, minute, err = parseTime(strings.Join(rest[1:], "")); err != nil {
+			return err
+		}
+	} else if lower[0] == "today" {
+		return ErrNoWhen
+	}
+
+	at := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+
+	// A time of day without a day means the next time it comes around.
+	if lower[0] == "at" && !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	if !at.After(now) {
+		return ErrPast
+	}
+
+	r.At = at
+	return nil
+}
+
+// parseEvery parses a recurring schedule such as `day at 9`, `weekday`,
+// `hour` or `monday at 10` into a cron expression.
+func (r *Reminder) parseEvery(lower []string, words []string) error {
+	if len(lower) == 0 {
+		return ErrNoWhen
+	}
+
+	hour, minute := defaultHour, 0
+	if len(lower) > 1 {
+		if lower[1] != "at" || len(lower) == 2 || lower[0] == "hour" {
+			return ErrNoWhen
+		}
+
+		var err error
+		if hour, minute, err = parseTime(strings.Join(lower[2:], "")); err != nil {
+			return err
+		}
+	}
+
+	var days string
+	switch lower[0] {
+	case "hour":
+		r.Every = "0 * * * *"
+		r.Recurrence = strings.Join(words, " ")
+		return nil
+	case "day":
+		days = "*"
+	case "weekday":
+		days = "1-5"
+	default:
+		weekday, ok := weekdays[lower[0]]
+		if !ok {
+			weekday, ok = weekdays[strings.TrimSuffix(lower[0], "s")]
+		}
+		if !ok {
+			return ErrNoWhen
+		}
+		days = strconv.Itoa(int(weekday))
+	}
+
+	r.Every = fmt.Sprintf("%d %d * * %s", minute, hour, days)
+	r.Recurrence = strings.Join(words, " ")
+	return nil
+}
+
+// parseDay parses the day a reminder is for, returning the words following it.
+func parseDay(lower []string, now time.Time) (time.Time, []string, error) {
+	switch lower[0] {
+	case "at":
+		return now, lower, nil
+	case "today":
+		return now, lower[1:], nil
+	case "tomorrow":
+		return now.AddDate(0, 0, 1), lower[1:], nil
+	case "on", "next":
+		if len(lower) == 1 {
+			return time.Time{}, nil, ErrNoWhen
+		}
+
+		if day, err := time.ParseInLocation("2006-01-02", lower[1], now.Location()); err == nil {
+			return day, lower[2:], nil
+		}
+
+		lower = lower[1:]
+	}
+
+	weekday, ok := weekdays[lower[0]]
+	if !ok {
+		return time.Time{}, nil, ErrNoWhen
+	}
+
+	// The next such day, never today.
+	days := (int(weekday) - int(now.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+
+	return now.AddDate(0, 0, days), lower[1:], nil
+}
+
+// parseDuration parses a relative time such as `2h`, `90 minutes`,
+// `an hour` or `1h30m`.
+func parseDuration(lower []string) (time.Duration, error) {
+	switch len(lower) {
+	case 1:
+		if d, err := time.ParseDuration(lower[0]); err == nil && d > 0 {
+			return d, nil
+		}
+
+		// Units Go's durations do not know, such as `2d`.
+		i := strings.IndexFunc(lower[0], func(c rune) bool { return c < '0' || c > '9' })
+		if i > 0 {
+			return parseDuration([]string{lower[0][:i], lower[0][i:]})
+		}
+	case 2:
+		n, err := strconv.Atoi(lower[0])
+		if lower[0] == "a" || lower[0] == "an" {
+			n, err = 1, nil
+		}
+
+		unit, ok := units[lower[1]]
+		if err == nil && ok && n > 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	return 0, ErrNoWhen
+}
+
+// parseTime parses a time of day such as `9`, `9:30am`, `21:00`, `noon`
+// or `midnight`.
+func parseTime(text string) (int, int, error) {
+	switch text {
+	case "noon":
+		return 12, 0, nil
+	case "midnight":
+		return 0, 0, nil
+	}
+
+	matches := timeRegexp.FindStringSubmatch(text)
+	if matches == nil {
+		return 0, 0, ErrNoWhen
+	}
+
+	hour, _ := strconv.Atoi(matches[1])
+	minute := 0
+	if matches[2] != "" {
+		minute, _ = strconv.Atoi(matches[2])
+	}
+
+	if matches[3] != "" && (hour < 1 || hour > 12) {
+		return 0, 0, ErrNoWhen
+	}
+
+	switch matches[3] {
+	case "am":
+		hour %= 12
+	case "pm":
+		hour = hour%12 + 12
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, ErrNoWhen
+	}
+
+	return hour, minute, nil
+}
@@ -0,0 +1,80 @@
+package reminders_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin/plugin/reminders"
+)
+
+func TestParse(t *testing.T) {
+	// Saturday 30 December 2017, 10:15.
+	now := time.Date(2017, time.December, 30, 10, 15, 0, 0, time.UTC)
+	at := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2017, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		text   string
+		target string
+		what   string
+		at     time.Time
+		every  string
+	}{
+		{"me in 2h to stretch", "me", "stretch", now.Add(2 * time.Hour), ""},
+		{"me in 90 minutes to stretch", "me", "stretch", now.Add(90 * time.Minute), ""},
+		{"me in an hour to stretch", "me", "stretch", now.Add(time.Hour), ""},
+		{"me in 2d to stretch", "me", "stretch", now.Add(48 * time.Hour), ""},
+		{"me to go to the shop in 1h30m", "me", "go to the shop", now.Add(90 * time.Minute), ""},
+		{"me tomorrow at 9 to review PR", "me", "review PR", at(time.December, 31, 9, 0), ""},
+		{"me tomorrow to review PR", "me", "review PR", at(time.December, 31, 9, 0), ""},
+		{"me at 3pm to call mom", "me", "call mom", at(time.December, 30, 15, 0), ""},
+		{"me at 9:30 to call mom", "me", "call mom", at(time.December, 31, 9, 30), ""},
+		{"me today at noon to eat", "me", "eat", at(time.December, 30, 12, 0), ""},
+		{"me to log in at 9 pm", "me", "log in", at(time.December, 30, 21, 0), ""},
+		{"@ford on monday to bring a towel", "@ford", "bring a towel", time.Date(2018, time.January, 1, 9, 0, 0, 0, time.UTC), ""},
+		{"@ford to bring a towel saturday at 8", "@ford", "bring a towel", time.Date(2018, time.January, 6, 8, 0, 0, 0, time.UTC), ""},
+		{"me on 2018-02-01 at 17:45 to file taxes", "me", "file taxes", time.Date(2018, time.February, 1, 17, 45, 0, 0, time.UTC), ""},
+		{"#ops every monday at 10 to rotate the on-call", "#ops", "rotate the on-call", time.Time{}, "0 10 * * 1"},
+		{"#ops to stand up every weekday at 9:15am", "#ops", "stand up", time.Time{}, "15 9 * * 1-5"},
+		{"me every day to drink water", "me", "drink water", time.Time{}, "0 9 * * *"},
+		{"me every hour to drink water", "me", "drink water", time.Time{}, "0 * * * *"},
+		{"me every thursdays at 5pm to play", "me", "play", time.Time{}, "0 17 * * 4"},
+	}
+
+	for _, test := range tests {
+		r, err := reminders.Parse(test.text, now)
+		if err != nil {
+			t.Errorf("%s: Parse should not have returned an error, got %s", test.text, err)
+			continue
+		}
+
+		if r.Target != test.target || r.Text != test.what || !r.At.Equal(test.at) || r.Every != test.every {
+			t.Errorf("%s: expected %s, %q, %s, %q, got %s, %q, %s, %q", test.text, test.target, test.what, test.at, test.every, r.Target, r.Text, r.At, r.Every)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Date(2017, time.December, 30, 10, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		text string
+		err  error
+	}{
+		{"", reminders.ErrNoTarget},
+		{"everyone in 2h to stretch", reminders.ErrNoTarget},
+		{"me in 2h", reminders.ErrNoText},
+		{"me to stretch", reminders.ErrNoWhen},
+		{"me to stretch in a while", reminders.ErrNoWhen},
+		{"me at 25:00 to stretch", reminders.ErrNoWhen},
+		{"me today at 8 to stretch", reminders.ErrPast},
+		{"me every fortnight to stretch", reminders.ErrNoWhen},
+	}
+
+	for _, test := range tests {
+		if _, err := reminders.Parse(test.text, now); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.text, test.err, err)
+		}
+	}
+}
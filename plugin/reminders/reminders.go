@@ -0,0 +1,465 @@
+// Package reminders implements a plugin reminding users and channels of
+// things, once or on a schedule, as in `remind me tomorrow at 9 to review
+// the PR` or `remind #ops every monday at 10 to rotate the on-call`.
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+)
+
+// DefaultSnooze is how long reminders are snoozed for by default.
+const DefaultSnooze = 10 * time.Minute
+
+// category is the help category of the plugin's commands.
+const category = "Reminders"
+
+// jobKind is the kind of the robot's one-off jobs delivering reminders.
+const jobKind = "reminder"
+
+// Reminder describes a reminder.
+type Reminder struct {
+	// At is when a one-off reminder is delivered.
+	At time.Time `json:"at,omitempty"`
+
+	// Channel is the ID of the channel the reminder was set in.
+	Channel string `json:"channel"`
+
+	Creator     string `json:"creator"`
+	CreatorName string `json:"creatorName"`
+
+	// Every is the cron expression of a recurring reminder, and
+	// Recurrence how it was phrased.
+	Every      string `json:"every,omitempty"`
+	Recurrence string `json:"recurrence,omitempty"`
+
+	ID    string `json:"id"`
+	JobID string `json:"jobID,omitempty"`
+
+	// Target is who to remind: `me`, a `#channel` or an `@user`.
+	Target string `json:"target"`
+
+	Text string `json:"text"`
+}
+
+// Plugin describes the reminders plugin.
+type Plugin struct {
+	jobs  map[string]*marvin.Job
+	mu    sync.Mutex
+	robot *marvin.Robot
+	store *marvin.Namespace
+
+	// Location is the time zone times such as `tomorrow at 9` are
+	// interpreted in.
+	Location *time.Location
+
+	// Snooze is how long reminders are snoozed for when no duration is given.
+	Snooze time.Duration
+}
+
+// New creates a new reminders plugin and returns a pointer to it.
+func New() *Plugin {
+	return &Plugin{
+		jobs:     map[string]*marvin.Job{},
+		Location: time.Local,
+		Snooze:   DefaultSnooze,
+	}
+}
+
+// Register registers the plugin's listeners with the robot and schedules
+// its stored reminders. Pass it to Robot.RegisterPlugin.
+func (p *Plugin) Register(robot *marvin.Robot) {
+	p.robot = robot
+	p.store = robot.Namespace("reminders")
+
+	robot.RegisterJob(jobKind, p.deliverJob)
+
+	robot.RespondHandler(`(?is)^remind\s+(.+)$`, p.remind,
+		marvin.WithCategory(category),
+		marvin.WithDescription("Reminds you, a #channel or an @user of something, once or on a schedule."),
+		marvin.WithExamples(
+			"remind me in 2h to stretch",
+			"remind me tomorrow at 9 to review the PR",
+			"remind #ops every monday at 10 to rotate the on-call",
+		),
+		marvin.WithName("remind"),
+	)
+
+	commands := []*marvin.Command{
+		{
+			Name:        "reminders",
+			Category:    category,
+			Description: "Lists the reminders you have set.",
+			Handler:     p.list,
+		},
+		{
+			Name:        "forget",
+			Args:        []marvin.Arg{{Name: "reminder"}},
+			Category:    category,
+			Description: "Cancels one of the reminders you have set.",
+			Examples:    []string{"forget 3"},
+			Handler:     p.forget,
+		},
+		{
+			Name:        "snooze",
+			Args:        []marvin.Arg{{Name: "duration", Optional: true, Rest: true}},
+			Category:    category,
+			Description: "Reminds you again later of the last reminder you got, or postpones one you have set.",
+			Examples:    []string{"snooze", "snooze for 1h", "snooze 2 days --reminder=3"},
+			Flags:       []marvin.Flag{{Name: "reminder", Type: marvin.ArgInt}},
+			Handler:     p.snooze,
+		},
+	}
+
+	for _, cmd := range commands {
+		if _, err := robot.Command(cmd); err != nil {
+			log.Printf("reminders: error registering %s: %s", cmd.Name, err)
+		}
+	}
+
+	reminders, err := p.reminders()
+	if err != nil {
+		log.Printf("reminders: error loading reminders: %s", err)
+	}
+
+	for _, r := range reminders {
+		if r.Every != "" {
+			if err := p.schedule(r); err != nil {
+				log.Printf("reminders: error scheduling reminder %s: %s", r.ID, err)
+			}
+		}
+	}
+}
+
+// add stores the reminder and schedules its delivery.
+func (p *Plugin) add(r *Reminder) error {
+	if err := p.store.Set("reminder:"+r.ID, r); err != nil {
+		return err
+	}
+
+	if r.Every != "" {
+		return p.schedule(r)
+	}
+
+	id, err := p.robot.At(r.At, jobKind, r.ID)
+	if err != nil {
+		p.store.Delete("reminder:" + r.ID)
+		return err
+	}
+
+	r.JobID = id
+	return p.store.Set("reminder:"+r.ID, r)
+}
+
+// deliver sends the reminder to whoever it is for. Users are reminded in
+// a direct message if possible, or else in the channel the reminder was
+// set in.
+func (p *Plugin) deliver(r *Reminder) error {
+	text := "Reminder: " + r.Text
+
+	switch {
+	case strings.HasPrefix(r.Target, "#"):
+		return p.robot.Send(strings.TrimPrefix(r.Target, "#"), text)
+	case r.Target == "me":
+		if r.Every == "" {
+			text += " (say `snooze` to be reminded again later)"
+		}
+
+		if err := p.robot.SendDirect(r.CreatorName, text); err == nil {
+			return nil
+		}
+
+		return p.robot.SendByID(r.Channel, "@"+r.CreatorName+" "+text)
+	default:
+		if err := p.robot.SendDirect(strings.TrimPrefix(r.Target, "@"), text); err == nil {
+			return nil
+		}
+
+		return p.robot.SendByID(r.Channel, r.Target+" "+text)
+	}
+}
+
+// deliverJob delivers the one-off reminder whose ID is in data. The
+// reminder is kept around as the creator's last one, so it can be snoozed.
+func (p *Plugin) deliverJob(ctx context.Context, data json.RawMessage) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+
+	r := &Reminder{}
+	if err := p.store.Get("reminder:"+id, r); err == marvin.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := p.store.Delete("reminder:" + id); err != nil {
+		return err
+	}
+
+	if r.Target == "me" {
+		r.JobID = ""
+		if err := p.store.Set("last:"+r.Creator, r); err != nil {
+			return err
+		}
+	}
+
+	return p.deliver(r)
+}
+
+// describe returns who and when the reminder is for, as in `you at 09:00 on Mon 1 Jan`.
+func (p *Plugin) describe(r *Reminder) string {
+	who := r.Target
+	if who == "me" {
+		who = "you"
+	}
+
+	if r.Every != "" {
+		return who + " " + r.Recurrence
+	}
+
+	return who + " at " + r.At.In(p.Location).Format("15:04 on Mon 2 Jan")
+}
+
+// forget cancels one of the user's reminders.
+func (p *Plugin) forget(req *marvin.Request, args marvin.Args) error {
+	r, err := p.own(req, args.String("reminder"))
+	if err != nil {
+		return err
+	} else if r == nil {
+		return req.Reply(fmt.Sprintf("You don't have a reminder %s.", args.String("reminder")))
+	}
+
+	if err := p.remove(r); err != nil {
+		return err
+	}
+
+	return req.Reply(fmt.Sprintf("OK, I've forgotten reminder %s.", r.ID))
+}
+
+// list lists the user's reminders.
+func (p *Plugin) list(req *marvin.Request, args marvin.Args) error {
+	if req.Message.User == nil {
+		return nil
+	}
+
+	reminders, err := p.reminders()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, r := range reminders {
+		if r.Creator == req.Message.User.ID {
+			lines = append(lines, fmt.Sprintf("`%s` %s - %s", r.ID, r.Text, p.describe(r)))
+		}
+	}
+
+	if len(lines) == 0 {
+		return req.Reply("You don't have any reminders. Say `help remind` to find out how to set one.")
+	}
+
+	return req.Reply(strings.Join(lines, "\n"))
+}
+
+// nextID returns the ID for a new reminder.
+func (p *Plugin) nextID() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	if err := p.store.Get("seq", &n); err != nil && err != marvin.ErrNotFound {
+		return "", err
+	}
+
+	n++
+	if err := p.store.Set("seq", n); err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(n), nil
+}
+
+// now returns the current time in the plugin's location.
+func (p *Plugin) now() time.Time {
+	return time.Now().In(p.Location)
+}
+
+// own returns the reminder with the given ID, or nil if the request's user
+// did not set it.
+func (p *Plugin) own(req *marvin.Request, id string) (*Reminder, error) {
+	if req.Message.User == nil {
+		return nil, nil
+	}
+
+	r := &Reminder{}
+	if err := p.store.Get("reminder:"+id, r); err == marvin.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if r.Creator != req.Message.User.ID {
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// reminders returns all stored reminders, in order of ID.
+func (p *Plugin) reminders() ([]*Reminder, error) {
+	keys, err := p.store.Keys("reminder:")
+	if err != nil {
+		return nil, err
+	}
+
+	var reminders []*Reminder
+	for _, key := range keys {
+		r := &Reminder{}
+		if err := p.store.Get(key, r); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+
+	sort.Slice(reminders, func(i, j int) bool {
+		a, _ := strconv.Atoi(reminders[i].ID)
+		b, _ := strconv.Atoi(reminders[j].ID)
+		return a < b
+	})
+
+	return reminders, nil
+}
+
+// remind sets a reminder.
+func (p *Plugin) remind(req *marvin.Request) error {
+	if req.Message.User == nil {
+		return nil
+	}
+
+	r, err := Parse(req.Query[0], p.now())
+	if err != nil {
+		return req.Reply("Sorry, " + err.Error())
+	}
+
+	if r.ID, err = p.nextID(); err != nil {
+		return err
+	}
+
+	if req.Message.Channel != nil {
+		r.Channel = req.Message.Channel.ID
+	}
+	r.Creator = req.Message.User.ID
+	r.CreatorName = req.Message.User.Name
+
+	if err := p.add(r); err != nil {
+		return err
+	}
+
+	return req.Reply(fmt.Sprintf("OK, I'll remind %s. That's reminder %s.", p.describe(r), r.ID))
+}
+
+// remove cancels and deletes the reminder.
+func (p *Plugin) remove(r *Reminder) error {
+	if r.Every != "" {
+		p.mu.Lock()
+		if job, ok := p.jobs[r.ID]; ok {
+			job.Stop()
+			delete(p.jobs, r.ID)
+		}
+		p.mu.Unlock()
+	} else if err := p.robot.CancelJob(r.JobID); err != nil {
+		return err
+	}
+
+	return p.store.Delete("reminder:" + r.ID)
+}
+
+// schedule schedules the delivery of a recurring reminder.
+func (p *Plugin) schedule(r *Reminder) error {
+	job, err := p.robot.Schedule(r.Every, func(ctx context.Context) {
+		if err := p.deliver(r); err != nil {
+			log.Printf("reminders: error delivering reminder %s: %s", r.ID, err)
+		}
+	}, marvin.WithLocation(p.Location))
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.jobs[r.ID] = job
+	p.mu.Unlock()
+
+	return nil
+}
+
+// snooze reminds the user again of the last reminder they got, or
+// postpones one of their pending reminders.
+func (p *Plugin) snooze(req *marvin.Request, args marvin.Args) error {
+	if req.Message.User == nil {
+		return nil
+	}
+
+	id := ""
+	if args.Has("reminder") {
+		id = strconv.Itoa(args.Int("reminder"))
+	}
+
+	words := strings.Fields(strings.ToLower(args.String("duration")))
+	if len(words) > 0 && words[0] == "for" {
+		words = words[1:]
+	}
+
+	d := p.Snooze
+	if len(words) > 0 {
+		var err error
+		if d, err = parseDuration(words); err != nil {
+			return req.Reply("Sorry, I don't know how long that is. Try `snooze for 1h`.")
+		}
+	}
+
+	var r *Reminder
+	if id == "" {
+		r = &Reminder{}
+		if err := p.store.Get("last:"+req.Message.User.ID, r); err == marvin.ErrNotFound {
+			return req.Reply("There's nothing to snooze.")
+		} else if err != nil {
+			return err
+		}
+
+		r.At = p.now().Add(d)
+		if err := p.store.Delete("last:" + r.Creator); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if r, err = p.own(req, id); err != nil {
+			return err
+		} else if r == nil {
+			return req.Reply(fmt.Sprintf("You don't have a reminder %s.", id))
+		} else if r.Every != "" {
+			return req.Reply("Recurring reminders can't be snoozed, only forgotten.")
+		}
+
+		if err := p.robot.CancelJob(r.JobID); err != nil {
+			return err
+		}
+		r.At = r.At.Add(d)
+	}
+
+	if err := p.add(r); err != nil {
+		return err
+	}
+
+	return req.Reply(fmt.Sprintf("OK, I'll remind %s. That's reminder %s.", p.describe(r), r.ID))
+}
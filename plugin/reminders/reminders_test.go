@@ -0,0 +1,122 @@
+package reminders_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+	"github.com/chielkunkels/marvin/plugin/reminders"
+)
+
+var testAddress = "127.0.0.1:0"
+
+// newTestRobot returns an open robot with the reminders plugin registered,
+// and a function sending a message to it and waiting for its reply.
+func newTestRobot(t *testing.T, adapter *mock.Adapter, brain marvin.Brain) (*marvin.Robot, func(string) string) {
+	replies := make(chan string, 10)
+	adapter.OnReply = func(m *marvin.Message, text string) {
+		replies <- text
+	}
+
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Brain = brain
+	robot.RegisterPlugin(reminders.New().Register)
+
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	say := func(text string) string {
+		adapter.PushMessage(&marvin.Message{
+			Channel: &marvin.Channel{ID: "C1", Name: "general"},
+			User:    &marvin.User{ID: "U1", Name: "arthur"},
+			Text:    "marvin " + text,
+		})
+
+		select {
+		case reply := <-replies:
+			return reply
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for a reply to %q", text)
+			return ""
+		}
+	}
+
+	return robot, say
+}
+
+func TestRemind(t *testing.T) {
+	adapter := mock.NewAdapter()
+	delivered := make(chan string, 10)
+	adapter.OnSendDirectMessage = func(user string, text string) {
+		delivered <- user + ": " + text
+	}
+
+	robot, say := newTestRobot(t, adapter, marvin.NewMemoryBrain())
+	defer robot.Close()
+
+	if reply := say("remind me in 100ms to stretch"); !strings.HasSuffix(reply, "That's reminder 1.") {
+		t.Errorf("Expected the reminder to be confirmed, got %q", reply)
+	}
+
+	select {
+	case text := <-delivered:
+		if !strings.HasPrefix(text, "arthur: Reminder: stretch") {
+			t.Errorf("Expected the reminder to be sent to arthur, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the reminder")
+	}
+
+	if reply := say("snooze for 100ms"); !strings.HasSuffix(reply, "That's reminder 1.") {
+		t.Errorf("Expected the snooze to be confirmed, got %q", reply)
+	}
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the snoozed reminder")
+	}
+
+	if reply := say("snooze"); !strings.HasSuffix(reply, "That's reminder 1.") {
+		t.Errorf("Expected the snooze to be confirmed, got %q", reply)
+	}
+
+	if reply := say("forget 1"); reply != "OK, I've forgotten reminder 1." {
+		t.Errorf("Expected the reminder to be forgotten, got %q", reply)
+	}
+
+	if reply := say("snooze"); reply != "There's nothing to snooze." {
+		t.Errorf("Expected nothing to snooze, got %q", reply)
+	}
+}
+
+func TestReminders(t *testing.T) {
+	brain := marvin.NewMemoryBrain()
+
+	robot, say := newTestRobot(t, mock.NewAdapter(), brain)
+	say("remind me tomorrow at 9 to review the PR")
+	say("remind #ops every monday at 10 to rotate the on-call")
+	say("remind me in 2h")
+	robot.Close()
+
+	// Reminders survive a restart.
+	robot, say = newTestRobot(t, mock.NewAdapter(), brain)
+	defer robot.Close()
+
+	reply := say("reminders")
+	lines := strings.Split(reply, "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "`1` review the PR - you at 09:00 on") || lines[1] != "`2` rotate the on-call - #ops every monday at 10" {
+		t.Errorf("Expected both reminders to be listed, got %q", reply)
+	}
+
+	if reply := say("forget 3"); reply != "You don't have a reminder 3." {
+		t.Errorf("Expected an unknown reminder not to be forgotten, got %q", reply)
+	}
+
+	if reply := say("snooze --reminder=2"); reply != "Recurring reminders can't be snoozed, only forgotten." {
+		t.Errorf("Expected a recurring reminder not to be snoozed, got %q", reply)
+	}
+}
@@ -0,0 +1,344 @@
+package marvin
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuckets is the number of buckets a limiter keeps before it starts
+// forgetting full ones.
+const maxBuckets = 1024
+
+// maxQueuedMessages is the number of messages the outbox queues per channel.
+const maxQueuedMessages = 100
+
+// Limit describes a token bucket rate limit: up to Burst events at once,
+// after which one more is allowed every Every. The zero Limit is unlimited.
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+// unlimited returns whether the limit allows any number of events.
+func (l Limit) unlimited() bool {
+	return l.Burst <= 0 || l.Every <= 0
+}
+
+// RateLimitError describes a request that was refused for exceeding a rate limit.
+type RateLimitError struct {
+	// Wait is how long until the request would be allowed.
+	Wait time.Duration
+
+	warn bool
+}
+
+// Error returns the error
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, try again in %s", e.Wait)
+}
+
+// bucket describes the tokens left for a single key.
+type bucket struct {
+	last   time.Time
+	tokens float64
+	warned bool
+}
+
+// limiter keeps a token bucket per key.
+type limiter struct {
+	buckets map[string]*bucket
+	limit   Limit
+	mu      sync.Mutex
+}
+
+// newLimiter creates a new limiter and returns a pointer to it, or nil if
+// the limit is unlimited.
+func newLimiter(limit Limit) *limiter {
+	if limit.unlimited() {
+		return nil
+	}
+
+	return &limiter{buckets: map[string]*bucket{}, limit: limit}
+}
+
+// check returns the error take would return for the key, without taking
+// a token.
+func (l *limiter) check(key string, now time.Time) *RateLimitError {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.refuse(l.refill(key, now))
+}
+
+// refill returns the key's bucket, topped up with the tokens that became
+// available since it was last used.
+func (l *limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+
+		b = &bucket{last: now, tokens: float64(l.limit.Burst)}
+		l.buckets[key] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(l.limit.Every)
+	if b.tokens > float64(l.limit.Burst) {
+		b.tokens = float64(l.limit.Burst)
+	}
+	b.last = now
+
+	return b
+}
+
+// refuse returns an error saying how long until a token is available, and
+// whether this is the first refusal since the last token was taken, if the
+// bucket is empty.
+func (l *limiter) refuse(b *bucket) *RateLimitError {
+	if b.tokens >= 1 {
+		return nil
+	}
+
+	err := &RateLimitError{
+		Wait: time.Duration((1 - b.tokens) * float64(l.limit.Every)),
+		warn: !b.warned,
+	}
+	b.warned = true
+	return err
+}
+
+// take takes a token from the key's bucket, or returns the error check
+// returns if the bucket is empty.
+func (l *limiter) take(key string, now time.Time) *RateLimitError {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	if err := l.refuse(b); err != nil {
+		return err
+	}
+
+	b.tokens--
+	b.warned = false
+	return nil
+}
+
+// prune forgets the buckets that would be full by now.
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))/float64(l.limit.Every) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// WithRateLimit limits how often each user can run the listener.
+func WithRateLimit(limit Limit) ListenerOption {
+	return func(l *Listener) {
+		l.limiter = newLimiter(limit)
+	}
+}
+
+// checkRate takes a token from the listener's bucket for the request's
+// user. The first time a listener directed at the robot is about to run
+// for a message, tokens are also taken from the buckets of the message's
+// user and channel, if both have one; listeners overhearing messages are
+// not charged.
+func (r *Robot) checkRate(l *Listener, req *Request, charged *bool) *RateLimitError {
+	now := time.Now()
+
+	userID, channelID := "", ""
+	if req.Message.User != nil {
+		userID = req.Message.User.ID
+	}
+	if req.Message.Channel != nil {
+		channelID = req.Message.Channel.ID
+	}
+
+	if l.direct && !*charged {
+		r.mu.RLock()
+		users, channels := r.userLimiter, r.channelLimiter
+		r.mu.RUnlock()
+
+		if err := users.check(userID, now); err != nil {
+			return err
+		}
+
+		if err := channels.check(channelID, now); err != nil {
+			return err
+		}
+
+		// Another message may have taken the last token since checking.
+		if err := users.take(userID, now); err != nil {
+			return err
+		}
+
+		if err := channels.take(channelID, now); err != nil {
+			return err
+		}
+
+		*charged = true
+	}
+
+	return l.limiter.take(userID, now)
+}
+
+// throttle tells the request's user to slow down, the first time they
+// exceed a limit.
+func (r *Robot) throttle(req *Request, err *RateLimitError) {
+	if !err.warn {
+		return
+	}
+
+	wait := err.Wait.Truncate(time.Second) + time.Second
+	if err := req.Reply(fmt.Sprintf("Easy there! Try again in %s.", wait)); err != nil {
+		r.handleError(req, err)
+	}
+}
+
+// outbox limits the rate of outgoing messages per channel. Messages that
+// cannot be sent right away are queued, and sent once the channel's limit
+// allows, those sent the same way together.
+type outbox struct {
+	limiter *limiter
+	mu      sync.Mutex
+	pending map[string][]*queuedMessage
+	timers  map[string]*time.Timer
+}
+
+// queuedMessage describes a message waiting to be sent. Consecutive
+// messages with the same group are sent as one, through the last one's
+// send function.
+type queuedMessage struct {
+	group string
+	send  func(string) error
+	text  string
+}
+
+// newOutbox creates a new outbox and returns a pointer to it, or nil if
+// the limit is unlimited.
+func newOutbox(limit Limit) *outbox {
+	if limit.unlimited() {
+		return nil
+	}
+
+	return &outbox{
+		limiter: newLimiter(limit),
+		pending: map[string][]*queuedMessage{},
+		timers:  map[string]*time.Timer{},
+	}
+}
+
+// send sends the text to the channel with the given key, if its limit
+// allows and nothing is queued for it yet. Otherwise the text is queued
+// and nil is returned, unless maxQueuedMessages are queued already, in
+// which case it returns ErrOutboxFull. Errors sending queued messages can
+// no longer be returned, so they are logged.
+func (o *outbox) send(key string, group string, text string, send func(string) error) error {
+	if o == nil {
+		return send(text)
+	}
+
+	o.mu.Lock()
+
+	m := &queuedMessage{group: group, send: send, text: text}
+
+	if queue, ok := o.pending[key]; ok {
+		defer o.mu.Unlock()
+
+		if len(queue) >= maxQueuedMessages {
+			return ErrOutboxFull
+		}
+
+		o.pending[key] = append(queue, m)
+		return nil
+	}
+
+	err := o.limiter.take(key, time.Now())
+	if err == nil {
+		o.mu.Unlock()
+		return send(text)
+	}
+
+	o.pending[key] = []*queuedMessage{m}
+	o.timers[key] = time.AfterFunc(err.Wait, func() { o.flushKey(key, false) })
+	o.mu.Unlock()
+
+	return nil
+}
+
+// flush sends everything that is queued, regardless of the limits.
+func (o *outbox) flush() {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	keys := make([]string, 0, len(o.pending))
+	for key := range o.pending {
+		o.timers[key].Stop()
+		keys = append(keys, key)
+	}
+	o.mu.Unlock()
+
+	for _, key := range keys {
+		o.flushKey(key, true)
+	}
+}
+
+// flushKey sends the messages queued for the channel with the given key,
+// each run of messages with the same group as a single message. Unless
+// forced, every message sent takes a token, and the rest wait for the next.
+func (o *outbox) flushKey(key string, force bool) {
+	for {
+		o.mu.Lock()
+		queue, ok := o.pending[key]
+		if !ok {
+			o.mu.Unlock()
+			return
+		}
+
+		if !force {
+			if err := o.limiter.take(key, time.Now()); err != nil {
+				o.timers[key] = time.AfterFunc(err.Wait, func() { o.flushKey(key, false) })
+				o.mu.Unlock()
+				return
+			}
+		}
+
+		n := 1
+		for n < len(queue) && queue[n].group == queue[0].group {
+			n++
+		}
+
+		texts := make([]string, n)
+		for i, m := range queue[:n] {
+			texts[i] = m.text
+		}
+		send := queue[n-1].send
+
+		if n == len(queue) {
+			delete(o.pending, key)
+			delete(o.timers, key)
+		} else {
+			o.pending[key] = queue[n:]
+		}
+		o.mu.Unlock()
+
+		if err := send(strings.Join(texts, "\n")); err != nil {
+			log.Printf("marvin: error sending queued messages: %s", err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package marvin_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func TestUserLimit(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.UserLimit = marvin.Limit{Burst: 2, Every: time.Hour}
+	robot.Open()
+	defer robot.Close()
+
+	called := 0
+	robot.Respond("^build", func(*marvin.Request) { called++ })
+
+	for i := 0; i < 4; i++ {
+		adapter.PushMessage(newTestMessage("1234", "marvin build"))
+	}
+	flush(t, robot, adapter, "1234")
+
+	if called != 2 {
+		t.Errorf("Expected the listener to run twice, got %d", called)
+	}
+
+	if len(adapter.Replies) != 1 || !strings.HasPrefix(adapter.Replies[0], "Easy there! Try again in ") {
+		t.Errorf("Expected a single request to slow down, got %q", adapter.Replies)
+	}
+
+	other := newTestMessage("1234", "marvin build")
+	other.User = &marvin.User{ID: "U2", Name: "ford"}
+	adapter.PushMessage(other)
+	flush(t, robot, adapter, "1234")
+
+	if called != 3 {
+		t.Errorf("Expected the listener to run for another user, got %d runs", called)
+	}
+}
+
+func TestChannelLimit(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.ChannelLimit = marvin.Limit{Burst: 1, Every: time.Hour}
+	robot.Open()
+	defer robot.Close()
+
+	heard := map[string]int{}
+	robot.Respond("^ping", func(r *marvin.Request) { heard[r.Message.Channel.ID]++ })
+	robot.Hear("pong", func(r *marvin.Request) { heard[r.Message.Channel.ID]++ })
+
+	for _, channel := range []string{"a", "b"} {
+		adapter.PushMessage(newTestMessage(channel, "marvin ping"))
+		adapter.PushMessage(newTestMessage(channel, "marvin ping"))
+		adapter.PushMessage(newTestMessage(channel, "pong"))
+		flush(t, robot, adapter, channel)
+	}
+
+	if heard["a"] != 2 || heard["b"] != 2 {
+		t.Errorf("Expected the direct listener to run once per channel, and the other always, got %v", heard)
+	}
+
+	if len(adapter.Replies) != 2 {
+		t.Errorf("Expected a request to slow down per channel, got %q", adapter.Replies)
+	}
+}
+
+func TestListenerRateLimit(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+	defer robot.Close()
+
+	deploys, pings := 0, 0
+	robot.Respond("^deploy", func(*marvin.Request) { deploys++ }, marvin.WithRateLimit(marvin.Limit{Burst: 1, Every: time.Hour}))
+	robot.Respond("^ping", func(*marvin.Request) { pings++ })
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	adapter.PushMessage(newTestMessage("1234", "marvin ping"))
+	adapter.PushMessage(newTestMessage("1234", "marvin ping"))
+	flush(t, robot, adapter, "1234")
+
+	if deploys != 1 || pings != 2 {
+		t.Errorf("Expected only the limited listener to be limited, got %d deploys and %d pings", deploys, pings)
+	}
+}
+
+func TestOutboundLimit(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.OutboundLimit = marvin.Limit{Burst: 1, Every: 50 * time.Millisecond}
+	robot.Open()
+
+	sent := make(chan string, 10)
+	adapter.OnSendMessage = func(channel string, text string) {
+		sent <- channel + ": " + text
+	}
+
+	for _, text := range []string{"one", "two", "three"} {
+		if err := robot.Send("general", text); err != nil {
+			t.Errorf("Send should not have returned an error, got %s", err)
+		}
+	}
+
+	expected := []string{"general: one", "general: two\nthree"}
+	for _, e := range expected {
+		select {
+		case text := <-sent:
+			if text != e {
+				t.Errorf("Expected %q, got %q", e, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for a message")
+		}
+	}
+
+	robot.Send("general", "four")
+	robot.Close()
+
+	if len(sent) != 1 {
+		t.Errorf("Expected queued messages to be sent on close, got %d", len(sent))
+	}
+}
+
+func TestChannelLimitKeepsUserToken(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.ChannelLimit = marvin.Limit{Burst: 1, Every: time.Hour}
+	robot.UserLimit = marvin.Limit{Burst: 1, Every: time.Hour}
+	robot.Open()
+	defer robot.Close()
+
+	called := 0
+	robot.Respond("^build", func(*marvin.Request) { called++ })
+
+	other := func(channel string) *marvin.Message {
+		m := newTestMessage(channel, "marvin build")
+		m.User = &marvin.User{ID: "U2", Name: "ford"}
+		return m
+	}
+
+	adapter.PushMessage(newTestMessage("a", "marvin build"))
+	adapter.PushMessage(other("a"))
+	flush(t, robot, adapter, "a")
+
+	// Being refused by the channel's limit does not cost U2 their token.
+	adapter.PushMessage(other("b"))
+	flush(t, robot, adapter, "b")
+
+	if called != 2 {
+		t.Errorf("Expected the listener to run twice, got %d", called)
+	}
+}
+
+func TestOutboundLimitReply(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.OutboundLimit = marvin.Limit{Burst: 1, Every: 20 * time.Millisecond}
+	robot.Open()
+	defer robot.Close()
+
+	sent := make(chan string, 10)
+	adapter.OnReply = func(m *marvin.Message, text string) {
+		sent <- "reply: " + text
+	}
+	adapter.OnSend = func(m *marvin.Message, text string) {
+		sent <- "send to " + m.Channel.ID + ": " + text
+	}
+
+	robot.Respond("^hi$", func(r *marvin.Request) {
+		r.Reply("one")
+		robot.SendByID("1234", "two")
+		r.Reply("three")
+		r.Reply("four")
+	})
+	adapter.PushMessage(newTestMessage("1234", "marvin hi"))
+
+	expected := []string{"reply: one", "send to 1234: two", "reply: three\nfour"}
+	for _, e := range expected {
+		select {
+		case text := <-sent:
+			if text != e {
+				t.Errorf("Expected %q, got %q", e, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for a message")
+		}
+	}
+}
+
+func TestOutboxFull(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.OutboundLimit = marvin.Limit{Burst: 1, Every: time.Hour}
+	robot.Open()
+	defer robot.Close()
+
+	var err error
+	for i := 0; i < 200 && err == nil; i++ {
+		err = robot.Send("general", "spam")
+	}
+
+	if err != marvin.ErrOutboxFull {
+		t.Errorf("Send should have returned ErrOutboxFull, got %v", err)
+	}
+}
@@ -1,27 +1,213 @@
 package marvin
 
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Request describes an incoming request.
 type Request struct {
+	ctx     context.Context
 	Message *Message
+
+	// Params holds the values of the named groups in the listener's
+	// pattern, such as `(?P<env>\w+)`.
+	Params map[string]string
+
 	Query   []string
 	robot   *Robot
+	stopped *bool
+}
+
+// ReplyOption describes an option that can be passed when replying.
+type ReplyOption func(*replyOptions)
+
+// replyOptions describes how to reply.
+type replyOptions struct {
+	broadcast bool
+	inThread  bool
+}
+
+// Broadcast makes a reply in a thread show up in the channel as well.
+func Broadcast() ReplyOption {
+	return func(o *replyOptions) {
+		o.broadcast = true
+	}
+}
+
+// InThread makes the reply start a thread from the request's message if it
+// was not posted in one.
+func InThread() ReplyOption {
+	return func(o *replyOptions) {
+		o.inThread = true
+	}
 }
 
-// NewRequest creates a new request and return a pointer to it.
+// NewRequest creates a new request and return a pointer to it. The
+// request's context is derived from the robot's context.
 func NewRequest(robot *Robot, message *Message, query []string) *Request {
 	return &Request{
+		ctx:     robot.ctx,
 		Message: message,
+		Params:  map[string]string{},
 		Query:   query,
 		robot:   robot,
+		stopped: new(bool),
+	}
+}
+
+// Context returns the request's context. It is cancelled when the robot
+// is closed or when the listener's timeout expires.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the request with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// param returns the value of the named parameter.
+func (r *Request) param(name string) (string, error) {
+	v, ok := r.Params[name]
+	if !ok || v == "" {
+		return "", fmt.Errorf("missing parameter %s", name)
 	}
+
+	return v, nil
 }
 
-// Reply sends a reply to the user sending the request.
-func (r *Request) Reply(text string) {
-	r.robot.adapter.Reply(r.Message, text)
+// ParamChannel resolves the named parameter, a channel mention such
+// as `#general`, to one of the channels known to the adapter.
+func (r *Request) ParamChannel(name string) (*Channel, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return nil, err
+	}
+
+	directory, ok := r.robot.adapter.(Directory)
+	if !ok {
+		return nil, ErrNoDirectory
+	}
+
+	channel, ok := directory.ChannelByName(strings.TrimPrefix(v, "#"))
+	if !ok {
+		return nil, ErrUnknownChannel
+	}
+
+	return channel, nil
 }
 
-// Send sends a message to the channel the request originated from.
-func (r *Request) Send(text string) {
-	r.robot.adapter.Send(r.Message, text)
+// ParamDuration returns the named parameter as a duration, such as `1h30m`.
+func (r *Request) ParamDuration(name string) (time.Duration, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.ParseDuration(v)
+}
+
+// ParamInt returns the named parameter as an int.
+func (r *Request) ParamInt(name string) (int, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(v)
+}
+
+// ParamUser resolves the named parameter, a user mention such
+// as `@marvin`, to one of the users known to the adapter.
+func (r *Request) ParamUser(name string) (*User, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return nil, err
+	}
+
+	directory, ok := r.robot.adapter.(Directory)
+	if !ok {
+		return nil, ErrNoDirectory
+	}
+
+	user, ok := directory.UserByName(strings.TrimPrefix(v, "@"))
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	return user, nil
+}
+
+// Stop prevents any further listeners from running for the request's message.
+func (r *Request) Stop() {
+	if r.stopped == nil {
+		r.stopped = new(bool)
+	}
+
+	*r.stopped = true
+}
+
+// Reply sends a reply to the user sending the request. Messages that were
+// posted in a thread are replied to in that thread, if the adapter is a
+// Threader. Replies count towards the channel's OutboundLimit, like Send.
+func (r *Request) Reply(text string, options ...ReplyOption) error {
+	o := &replyOptions{inThread: r.Message.ThreadID != ""}
+	for _, option := range options {
+		option(o)
+	}
+
+	key, group := "", "reply"
+	if r.Message.Channel != nil {
+		key = r.Message.Channel.ID
+	}
+	if r.Message.User != nil {
+		group += " " + r.Message.User.ID
+	}
+
+	threader, ok := r.robot.adapter.(Threader)
+	if !ok || !o.inThread {
+		return r.robot.send(key, group, text, func(text string) error {
+			return r.robot.adapter.Reply(r.Message, text)
+		})
+	}
+
+	// Replies in different threads, or broadcast or not, are sent apart.
+	group += fmt.Sprintf(" %s %s %t", r.Message.ThreadID, r.Message.ID, o.broadcast)
+	return r.robot.send(key, group, text, func(text string) error {
+		return threader.ReplyInThread(r.Message, text, o.broadcast)
+	})
+}
+
+// ReplyInThread is like Reply, but starts a thread from the request's
+// message if it was not posted in one.
+func (r *Request) ReplyInThread(text string, options ...ReplyOption) error {
+	return r.Reply(text, append(options, InThread())...)
+}
+
+// Send sends a message to the channel the request originated from. If the
+// channel's OutboundLimit is exceeded, the text is queued and nil is
+// returned, or ErrOutboxFull if too many messages are queued already.
+func (r *Request) Send(text string) error {
+	key := ""
+	if r.Message.Channel != nil {
+		key = r.Message.Channel.ID
+	}
+
+	return r.robot.send(key, "", text, func(text string) error {
+		return r.robot.adapter.Send(r.Message, text)
+	})
 }
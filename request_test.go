@@ -1,7 +1,10 @@
 package marvin_test
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/chielkunkels/marvin"
 	"github.com/chielkunkels/marvin/mock"
@@ -9,7 +12,7 @@ import (
 
 func TestNewRequest(t *testing.T) {
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 
 	m := &marvin.Message{
 		Channel: &marvin.Channel{ID: "1234", Name: "general"},
@@ -27,7 +30,7 @@ func TestNewRequest(t *testing.T) {
 
 func TestReply(t *testing.T) {
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 
 	m := &marvin.Message{
 		Channel: &marvin.Channel{ID: "1234", Name: "general"},
@@ -36,16 +39,56 @@ func TestReply(t *testing.T) {
 	}
 
 	request := marvin.NewRequest(robot, m, []string{})
-	request.Reply("stuff and things")
+	if err := request.Reply("stuff and things"); err != nil {
+		t.Error("Reply should not have returned an error")
+	}
 
 	if !adapter.ReplyCalled {
 		t.Error("Reply was not called on the adapter")
 	}
+
+	adapter.SetError(errors.New("oh noes"))
+	if err := request.Reply("stuff and things"); err == nil {
+		t.Error("Reply should have returned the adapter's error")
+	}
+}
+
+func TestReplyInThread(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+
+	var broadcasts []bool
+	adapter.OnReplyInThread = func(m *marvin.Message, text string, broadcast bool) {
+		broadcasts = append(broadcasts, broadcast)
+	}
+
+	m := newTestMessage("1234", "Testing!")
+	m.ID = "1503435956.000247"
+	request := marvin.NewRequest(robot, m, []string{})
+
+	request.Reply("top level")
+	if adapter.ReplyInThreadCalled {
+		t.Error("Replies to messages outside of threads should not be in a thread")
+	}
+
+	request.ReplyInThread("new thread")
+	request.Reply("broadcast", marvin.InThread(), marvin.Broadcast())
+
+	m.ThreadID = "1503435956.000100"
+	request.Reply("same thread")
+
+	if len(broadcasts) != 3 || broadcasts[0] || !broadcasts[1] || broadcasts[2] {
+		t.Errorf("Expected three replies in threads, the second broadcast, got %v", broadcasts)
+	}
+
+	if len(adapter.Replies) != 4 || adapter.Replies[3] != "same thread" {
+		t.Errorf("Unexpected replies %q", adapter.Replies)
+	}
 }
 
 func TestSend(t *testing.T) {
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 
 	m := &marvin.Message{
 		Channel: &marvin.Channel{ID: "1234", Name: "general"},
@@ -54,9 +97,112 @@ func TestSend(t *testing.T) {
 	}
 
 	request := marvin.NewRequest(robot, m, []string{})
-	request.Send("stuff and things")
+	if err := request.Send("stuff and things"); err != nil {
+		t.Error("Send should not have returned an error")
+	}
 
 	if !adapter.SendCalled {
 		t.Error("Reply was not called on the adapter")
 	}
+
+	adapter.SetError(errors.New("oh noes"))
+	if err := request.Send("stuff and things"); err == nil {
+		t.Error("Send should have returned the adapter's error")
+	}
+}
+
+func TestRequestStop(t *testing.T) {
+	// Requests built by hand, as in plugin tests, can be stopped too.
+	request := &marvin.Request{Message: newTestMessage("1234", "hi")}
+	request.Stop()
+}
+
+func TestContext(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+
+	request := marvin.NewRequest(robot, newTestMessage("1234", "Testing!"), []string{})
+	if request.Context().Err() != nil {
+		t.Error("Context should not have been cancelled yet")
+	}
+
+	robot.Close()
+
+	if request.Context().Err() != context.Canceled {
+		t.Error("Context should have been cancelled by closing the robot")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+
+	request := marvin.NewRequest(robot, newTestMessage("1234", "Testing!"), []string{})
+	r2 := request.WithContext(ctx)
+	if r2 == request || r2.Context() != ctx || request.Context() == ctx {
+		t.Error("WithContext should return a copy with the new context")
+	}
+}
+
+func TestParams(t *testing.T) {
+	adapter := mock.NewAdapter()
+	adapter.Channels = []*marvin.Channel{{ID: "C1", Name: "ops"}}
+	adapter.Users = []*marvin.User{{ID: "U1", Name: "arthur"}}
+
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var request *marvin.Request
+	robot.Respond(`^page (?P<user>@\S+) in (?P<channel>#\S+) every (?P<every>\S+) x(?P<times>\d+)(?: (?P<note>.+))?$`, func(r *marvin.Request) {
+		request = r
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "marvin page @arthur in #ops every 1h30m x3"))
+	flush(t, robot, adapter, "1234")
+
+	if request == nil {
+		t.Fatal("Listener was not called")
+	}
+
+	if request.Params["user"] != "@arthur" || request.Params["note"] != "" || len(request.Query) != 5 {
+		t.Errorf("Params were not populated properly: %v", request.Params)
+	}
+
+	if user, err := request.ParamUser("user"); err != nil || user.ID != "U1" {
+		t.Errorf("ParamUser should have resolved @arthur, got %v, %v", user, err)
+	}
+
+	if channel, err := request.ParamChannel("channel"); err != nil || channel.ID != "C1" {
+		t.Errorf("ParamChannel should have resolved #ops, got %v, %v", channel, err)
+	}
+
+	if d, err := request.ParamDuration("every"); err != nil || d != 90*time.Minute {
+		t.Errorf("ParamDuration should have returned 1h30m, got %s, %v", d, err)
+	}
+
+	if n, err := request.ParamInt("times"); err != nil || n != 3 {
+		t.Errorf("ParamInt should have returned 3, got %d, %v", n, err)
+	}
+
+	if _, err := request.ParamInt("note"); err == nil {
+		t.Error("ParamInt should have failed for a missing parameter")
+	}
+
+	if _, err := request.ParamInt("user"); err == nil {
+		t.Error("ParamInt should have failed for a non-numeric parameter")
+	}
+
+	adapter.Users = nil
+	adapter.Channels = nil
+
+	if _, err := request.ParamUser("user"); err != marvin.ErrUnknownUser {
+		t.Errorf("ParamUser should have returned ErrUnknownUser, got %v", err)
+	}
+
+	if _, err := request.ParamChannel("channel"); err != marvin.ErrUnknownChannel {
+		t.Errorf("ParamChannel should have returned ErrUnknownChannel, got %v", err)
+	}
 }
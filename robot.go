@@ -1,21 +1,98 @@
 package marvin
 
 import (
+	"context"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pressly/chi"
 )
 
+// Default settings.
+const (
+	DefaultAskTimeout      = 5 * time.Minute
+	DefaultHelpPageSize    = 10
+	DefaultQueueSize       = 64
+	DefaultShutdownTimeout = 10 * time.Second
+	DefaultWorkers         = 8
+)
+
 // Robot describes a robot.
 type Robot struct {
-	adapter   Adapter
-	address   string
-	listeners []*Listener
-	name      string
-	nameRegex *regexp.Regexp
-	plugins   []func(*Robot)
-	Router    *chi.Mux
+	accessMu       sync.Mutex
+	adapter        Adapter
+	address        string
+	auditMu        sync.Mutex
+	Brain          Brain
+	cancel         context.CancelFunc
+	channelLimiter *limiter
+	conversations  map[conversationKey]chan *Message
+	ctx            context.Context
+	dispatcher     *dispatcher
+	errorHandler   ErrorHandler
+	fallback       ListenerHandler
+	jobFuncs       map[string]JobFunc
+	jobs           sync.WaitGroup
+	listener       net.Listener
+	listeners      []*Listener
+	mu             sync.RWMutex
+	name           string
+	nameRegex      *regexp.Regexp
+	opened         bool
+	outbox         *outbox
+	pendingJobs    []*Job
+	plugins        []func(*Robot)
+	Router         *chi.Mux
+	server         *http.Server
+	timers         map[string]*time.Timer
+	userLimiter    *limiter
+
+	connectionHandlers  []func(ConnectionState)
+	connectionState     ConnectionState
+	listenerMiddlewares []ListenerMiddleware
+	receiveMiddlewares  []ReceiveMiddleware
+
+	// AskTimeout is how long questions wait for an answer by default.
+	AskTimeout time.Duration
+
+	// ChannelLimit limits how often the robot responds to messages directed
+	// at it in each channel, and UserLimit how often it does so for each
+	// user. Messages exceeding a limit are answered with a request to slow
+	// down. Both are unlimited by default.
+	ChannelLimit Limit
+	UserLimit    Limit
+
+	// HelpPageSize is the number of entries per page of help in direct messages.
+	HelpPageSize int
+
+	// OutboundLimit limits how often messages are sent to each channel
+	// through Request.Reply, Request.Send and Robot.Send. Messages exceeding
+	// it are queued and sent together once the limit allows; errors sending
+	// them are logged, as they can no longer be returned. It is unlimited
+	// by default.
+	OutboundLimit Limit
+
+	// QueueSize is the number of messages each channel can have waiting to
+	// be handled. Further messages from a channel whose queue is full are
+	// dropped, so a slow listener never holds up other channels.
+	QueueSize int
+
+	// ShutdownTimeout is how long Close waits for the HTTP server and
+	// in-flight listener callbacks to finish.
+	ShutdownTimeout time.Duration
+
+	// Workers is the maximum number of listener callbacks that run
+	// concurrently. Messages from the same channel are handled one at a
+	// time, in the order received. Listeners waiting for an answer to
+	// Request.Ask don't count, and don't hold up their channel.
+	Workers int
 }
 
 // NewRobot creates a new robot and returns a pointer to it.
@@ -25,79 +102,463 @@ func NewRobot(name string, adapter Adapter, address string) (*Robot, error) {
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	robot := &Robot{
-		adapter:   adapter,
-		address:   address,
-		name:      name,
-		nameRegex: nameRegex,
-		plugins:   []func(*Robot){},
-		Router:    chi.NewRouter(),
+		adapter:       adapter,
+		address:       address,
+		Brain:         NewMemoryBrain(),
+		cancel:        cancel,
+		conversations: map[conversationKey]chan *Message{},
+		ctx:           ctx,
+		errorHandler:  DefaultErrorHandler,
+		jobFuncs:      map[string]JobFunc{},
+		name:          name,
+		nameRegex:     nameRegex,
+		plugins:       []func(*Robot){},
+		Router:        chi.NewRouter(),
+		timers:        map[string]*time.Timer{},
+
+		AskTimeout:      DefaultAskTimeout,
+		HelpPageSize:    DefaultHelpPageSize,
+		QueueSize:       DefaultQueueSize,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Workers:         DefaultWorkers,
+	}
+
+	if _, err := robot.Command(helpCommand(robot)); err != nil {
+		return nil, err
+	}
+
+	if err := robot.addAccessCommands(); err != nil {
+		return nil, err
 	}
 
 	return robot, nil
 }
 
 // createListener adds a new listener.
-func (r *Robot) createListener(pattern string, callback ListenerCallback, direct bool) error {
+func (r *Robot) createListener(pattern string, handler ListenerHandler, direct bool, options []ListenerOption) (*Listener, error) {
 	regex, err := regexp.Compile(pattern)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	listener := &Listener{callback: callback, direct: direct, regex: regex}
-	r.listeners = append(r.listeners, listener)
-	return nil
+	listener := &Listener{
+		direct:     direct,
+		disabledIn: map[string]bool{},
+		handler:    handler,
+		regex:      regex,
+		robot:      r,
+	}
+	for _, option := range options {
+		option(listener)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Listeners are kept sorted by descending priority, in registration
+	// order within the same priority. The slice is copied rather than
+	// modified in place, as workers may be iterating over it.
+	i := sort.Search(len(r.listeners), func(i int) bool {
+		return r.listeners[i].priority < listener.priority
+	})
+
+	listeners := make([]*Listener, 0, len(r.listeners)+1)
+	listeners = append(listeners, r.listeners[:i]...)
+	listeners = append(listeners, listener)
+	listeners = append(listeners, r.listeners[i:]...)
+	r.listeners = listeners
+
+	return listener, nil
 }
 
-// receiveMessages listens for messages on the given channel.
+// receiveMessages listens for messages on the given channel and hands
+// them off to the dispatcher's workers until the robot is closed, or the
+// adapter closes the channel.
 func (r *Robot) receiveMessages(messages <-chan *Message) {
-	for m := range messages {
-		for _, listener := range r.listeners {
-			if listener.direct && !r.nameRegex.MatchString(m.Text) {
-				continue
+	for {
+		select {
+		case <-r.ctx.Done():
+			r.dispatcher.stop()
+			return
+		case m, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			key := ""
+			if m.Channel != nil {
+				key = m.Channel.ID
+			}
+
+			job := func(release func()) {
+				m.release = release
+				r.handleMessage(m)
+			}
+
+			if !r.dispatcher.dispatch(key, job) {
+				log.Printf("marvin: queue for channel %q is full, dropping message", key)
+			}
+		}
+	}
+}
+
+// handleMessage passes the message through the receive middleware and on to
+// a question waiting for it or the matching listeners. Messages still queued
+// when the robot is closed are dropped.
+func (r *Robot) handleMessage(m *Message) {
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("marvin: receive middleware panicked: %v\n%s", err, debug.Stack())
+		}
+	}()
+
+	r.mu.RLock()
+	middlewares := r.receiveMiddlewares
+	r.mu.RUnlock()
+
+	handler := MessageHandler(r.receive)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	handler(m)
+}
+
+// receive hands the message to a question waiting for an answer from its
+// user, or else to the matching listeners.
+func (r *Robot) receive(m *Message) {
+	if r.capture(m) {
+		return
+	}
+
+	r.matchListeners(m)
+}
+
+// matchListeners runs the callbacks of all listeners matching the message,
+// in order of priority, until one of them stops propagation. Listeners the
+// message's user is not authorized to run, or that would exceed a rate
+// limit, are skipped. Direct messages that no listener matched are passed
+// to the fallback handler.
+func (r *Robot) matchListeners(m *Message) {
+	r.mu.RLock()
+	listeners := r.listeners
+	fallback := r.fallback
+	r.mu.RUnlock()
+
+	direct := r.nameRegex.MatchString(m.Text)
+	stopped := new(bool)
+	matched, charged := false, false
+
+	for _, listener := range listeners {
+		if *stopped {
+			return
+		}
+
+		if listener.direct && !direct {
+			continue
+		}
+
+		if !listener.enabledIn(m.Channel) {
+			continue
+		}
+
+		text := m.Text
+		if listener.direct {
+			text = r.nameRegex.ReplaceAllString(m.Text, "")
+		}
+
+		matches := listener.regex.FindStringSubmatch(text)
+		if matches == nil {
+			continue
+		}
+
+		req := NewRequest(r, m, matches[1:])
+		req.stopped = stopped
+		for i, name := range listener.regex.SubexpNames() {
+			if name != "" {
+				req.Params[name] = matches[i]
+			}
+		}
+
+		if !listener.allows(req) {
+			continue
+		}
+
+		// Denials are only answered for messages directed at the robot,
+		// so as not to respond to every message a listener overhears.
+		if err := r.authorize(listener, req); err != nil {
+			if listener.direct {
+				matched = true
+				r.deny(listener, req, err)
 			}
+			continue
+		}
 
-			text := m.Text
+		if err := r.checkRate(listener, req, &charged); err != nil {
 			if listener.direct {
-				text = r.nameRegex.ReplaceAllString(m.Text, "")
+				matched = true
+				r.throttle(req, err)
 			}
 
-			matches := listener.regex.FindStringSubmatch(text)
-			if matches == nil {
-				continue
+			// Once the user or channel is over its limit, so are the other listeners.
+			if !charged {
+				return
 			}
+			continue
+		}
 
-			listener.callback(NewRequest(r, m, matches[1:]))
+		matched = true
+		if listener.exclusive {
+			req.Stop()
 		}
+
+		r.runListener(listener, req)
+	}
+
+	if direct && !matched && fallback != nil {
+		text := r.nameRegex.ReplaceAllString(m.Text, "")
+		r.runListener(&Listener{handler: fallback}, NewRequest(r, m, []string{text}))
 	}
 }
 
-// Close disconnects the robot's adapter.
+// runListener runs the listener's handler and passes any error it returns,
+// including panics, to the robot's error handler.
+func (r *Robot) runListener(listener *Listener, req *Request) {
+	if listener.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), listener.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	r.mu.RLock()
+	middlewares := r.listenerMiddlewares
+	r.mu.RUnlock()
+
+	handler := listener.handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	if err := callHandler(handler, req); err != nil {
+		r.handleError(req, err)
+	}
+}
+
+// send sends text through the outbox, which queues it if the outbound
+// limit of the channel with the given key is exceeded. Queued texts with
+// the same group are sent together.
+func (r *Robot) send(key string, group string, text string, send func(string) error) error {
+	r.mu.RLock()
+	outbox := r.outbox
+	r.mu.RUnlock()
+
+	return outbox.send(key, group, text, send)
+}
+
+// callHandler calls the handler, converting a panic into a *PanicError.
+func callHandler(handler ListenerHandler, req *Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Stack: debug.Stack(), Value: v}
+		}
+	}()
+
+	return handler(req)
+}
+
+// handleError passes the error to the robot's error handler, making
+// sure a panicking error handler cannot take down its worker.
+func (r *Robot) handleError(req *Request, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("marvin: error handler panicked: %v\n%s", v, debug.Stack())
+		}
+	}()
+
+	r.mu.RLock()
+	handler := r.errorHandler
+	r.mu.RUnlock()
+
+	handler(req, err)
+}
+
+// Addr returns the address the robot's HTTP server is listening on,
+// or nil if the robot has not been opened.
+func (r *Robot) Addr() net.Addr {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.listener == nil {
+		return nil
+	}
+
+	return r.listener.Addr()
+}
+
+// Close cancels the robot's context, shuts down its HTTP server, disconnects
+// its adapter and waits for in-flight listener callbacks and scheduled jobs
+// to finish. Waiting is bounded by the robot's ShutdownTimeout. Finally, the
+// robot's brain is closed if it can be.
 func (r *Robot) Close() error {
-	return r.adapter.Close()
+	r.cancel()
+	r.stopJobs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.ShutdownTimeout)
+	defer cancel()
+
+	r.mu.RLock()
+	server := r.server
+	dispatcher := r.dispatcher
+	outbox := r.outbox
+	r.mu.RUnlock()
+
+	var errs []error
+	if server != nil {
+		errs = append(errs, server.Shutdown(ctx))
+	}
+
+	outbox.flush()
+
+	errs = append(errs, r.adapter.Close())
+
+	if _, ok := r.adapter.(ConnectionNotifier); !ok {
+		r.connectionStateChanged(Disconnected)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if dispatcher != nil {
+			dispatcher.wait()
+		}
+		r.jobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ErrShutdownTimeout)
+	}
+
+	if closer, ok := r.Brain.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Context returns the robot's context, which is cancelled when the robot is closed.
+func (r *Robot) Context() context.Context {
+	return r.ctx
+}
+
+// Fallback sets the handler for messages directed at the robot that no
+// listener matched, such as unknown commands. Its request's query holds
+// the text of the message without the robot's name.
+func (r *Robot) Fallback(handler ListenerHandler) {
+	r.mu.Lock()
+	r.fallback = handler
+	r.mu.Unlock()
 }
 
 // Hear creates a listener for messages that are not necessarily directed at the robot.
-func (r *Robot) Hear(pattern string, callback ListenerCallback) error {
-	return r.createListener(pattern, callback, false)
+// The returned listener can be used to disable or remove it later on.
+func (r *Robot) Hear(pattern string, callback ListenerCallback, options ...ListenerOption) (*Listener, error) {
+	return r.createListener(pattern, callback.handler(), false, options)
+}
+
+// HearHandler is like Hear, but takes a handler that can return an error.
+func (r *Robot) HearHandler(pattern string, handler ListenerHandler, options ...ListenerOption) (*Listener, error) {
+	return r.createListener(pattern, handler, false, options)
+}
+
+// OnError sets the handler that is called with errors returned by, and
+// panics in, listener handlers. It replaces DefaultErrorHandler.
+func (r *Robot) OnError(handler ErrorHandler) {
+	r.mu.Lock()
+	r.errorHandler = handler
+	r.mu.Unlock()
 }
 
-// Open connects the robot through the adapter.
+// Open starts the robot's HTTP server, connects the robot through the adapter,
+// runs the registered plugins and arms the one-off jobs stored in the brain.
+// If the adapter fails to connect, the robot is shut down: a robot can only
+// be used once, and opening it again after it was shut down or closed
+// returns ErrRobotClosed. An empty address listens on `:http`.
 func (r *Robot) Open() error {
+	if r.ctx.Err() != nil {
+		return ErrRobotClosed
+	}
+
+	address := r.address
+	if address == "" {
+		address = ":http"
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: r.Router}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("marvin: http server stopped: %s", err)
+		}
+	}()
+
 	messages := make(chan *Message)
+	dispatcher := newDispatcher(r.Workers, r.QueueSize)
+
+	r.mu.Lock()
+	r.channelLimiter = newLimiter(r.ChannelLimit)
+	r.dispatcher = dispatcher
+	r.listener = listener
+	r.outbox = newOutbox(r.OutboundLimit)
+	r.server = server
+	r.userLimiter = newLimiter(r.UserLimit)
+	r.mu.Unlock()
+
 	go r.receiveMessages(messages)
 
-	go func() { http.ListenAndServe(r.address, r.Router) }()
+	if mounter, ok := r.adapter.(Mounter); ok {
+		mounter.Mount(r.Router)
+	}
+
+	notifier, ok := r.adapter.(ConnectionNotifier)
+	if ok {
+		notifier.OnConnectionState(r.connectionStateChanged)
+	}
 
 	if err := r.adapter.Open(messages); err != nil {
+		// Cancelling the context stops receiving messages and the dispatcher.
+		r.cancel()
+		server.Close()
 		return err
 	}
 
+	if !ok {
+		r.connectionStateChanged(Connected)
+	}
+
 	for _, plugin := range r.plugins {
 		plugin(r)
 	}
 
-	return nil
+	return r.startJobs()
 }
 
 // RegisterPlugin registers the given plugin.
@@ -106,11 +567,65 @@ func (r *Robot) RegisterPlugin(plugin func(*Robot)) {
 }
 
 // Respond creates a listener for messages directed at the robot.
-func (r *Robot) Respond(pattern string, callback ListenerCallback) error {
-	return r.createListener(pattern, callback, true)
+// The returned listener can be used to disable or remove it later on.
+func (r *Robot) Respond(pattern string, callback ListenerCallback, options ...ListenerOption) (*Listener, error) {
+	return r.createListener(pattern, callback.handler(), true, options)
+}
+
+// RespondHandler is like Respond, but takes a handler that can return an error.
+func (r *Robot) RespondHandler(pattern string, handler ListenerHandler, options ...ListenerOption) (*Listener, error) {
+	return r.createListener(pattern, handler, true, options)
 }
 
-// Send sends text to a channel.
+// Send sends text to a channel, by name. If the channel's OutboundLimit is
+// exceeded, the text is queued and nil is returned, or ErrOutboxFull if too
+// many messages are queued already.
 func (r *Robot) Send(channel string, text string) error {
-	return r.adapter.SendMessage(channel, text)
+	// Share the channel's outbound limit with requests from it.
+	key := channel
+	if directory, ok := r.adapter.(Directory); ok {
+		if c, ok := directory.ChannelByName(channel); ok {
+			key = c.ID
+		}
+	}
+
+	return r.send(key, "", text, func(text string) error {
+		return r.adapter.SendMessage(channel, text)
+	})
+}
+
+// SendByID is like Send, but takes the ID of the channel rather than its name.
+func (r *Robot) SendByID(channel string, text string) error {
+	return r.send(channel, "", text, func(text string) error {
+		return r.adapter.Send(&Message{Channel: &Channel{ID: channel}}, text)
+	})
+}
+
+// SendDirect sends text to a user by name, in a direct message.
+func (r *Robot) SendDirect(user string, text string) error {
+	messenger, ok := r.adapter.(DirectMessenger)
+	if !ok {
+		return ErrNoDirectMessages
+	}
+
+	return messenger.SendDirectMessage(user, text)
+}
+
+// Use appends listener middlewares to the robot. Listener middlewares wrap
+// the handler of every matched listener and run in the order they were
+// registered, the first one being the outermost.
+func (r *Robot) Use(middlewares ...ListenerMiddleware) {
+	r.mu.Lock()
+	r.listenerMiddlewares = append(r.listenerMiddlewares, middlewares...)
+	r.mu.Unlock()
+}
+
+// UseReceive appends receive middlewares to the robot. Receive middlewares
+// run for every incoming message before it is matched against listeners,
+// in the order they were registered. They may inspect or rewrite the
+// message, or drop it by not calling the next handler.
+func (r *Robot) UseReceive(middlewares ...ReceiveMiddleware) {
+	r.mu.Lock()
+	r.receiveMiddlewares = append(r.receiveMiddlewares, middlewares...)
+	r.mu.Unlock()
 }
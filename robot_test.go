@@ -2,21 +2,58 @@ package marvin_test
 
 import (
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/pressly/chi"
 
 	"github.com/chielkunkels/marvin"
 	"github.com/chielkunkels/marvin/mock"
 )
 
+var testAddress = "127.0.0.1:0"
+
+// newTestMessage creates a message in the given channel.
+func newTestMessage(channel string, text string) *marvin.Message {
+	return &marvin.Message{
+		Channel: &marvin.Channel{ID: channel, Name: channel},
+		User:    &marvin.User{ID: "4321", Name: "someperson"},
+		Text:    text,
+	}
+}
+
+// flush pushes a marker message into the given channel and waits for it to be
+// handled, which guarantees earlier messages in that channel were handled too.
+func flush(t *testing.T, robot *marvin.Robot, adapter *mock.Adapter, channel string) {
+	done := make(chan struct{})
+	listener, _ := robot.Hear("^__flush__$", func(r *marvin.Request) {
+		if r.Message.Channel.ID == channel {
+			close(done)
+		}
+	})
+	defer listener.Remove()
+
+	adapter.PushMessage(newTestMessage(channel, "__flush__"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for messages to be handled")
+	}
+}
+
 func TestNewRobot(t *testing.T) {
 	adapter := mock.NewAdapter()
 
-	_, err := marvin.NewRobot("mar[vin", adapter)
+	_, err := marvin.NewRobot("mar[vin", adapter, testAddress)
 	if err == nil {
 		t.Error("NewRobot should have failed with name `mar[vin`")
 	}
 
-	_, err = marvin.NewRobot("marvin", adapter)
+	_, err = marvin.NewRobot("marvin", adapter, testAddress)
 	if err != nil {
 		t.Error("NewRobot should not have failed with name `marvin`")
 	}
@@ -37,14 +74,10 @@ func Test_receiveMessages(t *testing.T) {
 
 	for _, test := range tests {
 		adapter := mock.NewAdapter()
-		robot, _ := marvin.NewRobot("marvin", adapter)
+		robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 		robot.Open()
 
-		m := &marvin.Message{
-			Channel: &marvin.Channel{ID: "1234", Name: "general"},
-			User:    &marvin.User{ID: "4321", Name: "someperson"},
-			Text:    test.Text,
-		}
+		m := newTestMessage("1234", test.Text)
 
 		called := false
 		callback := func(r *marvin.Request) {
@@ -58,16 +91,120 @@ func Test_receiveMessages(t *testing.T) {
 		}
 
 		adapter.PushMessage(m)
+		flush(t, robot, adapter, "1234")
 
 		if called != test.Called {
-			t.Error("Should not have been called")
+			t.Errorf("%q: expected called to be %t", test.Text, test.Called)
 		}
 	}
 }
 
+func Test_receiveMessagesOrder(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var received []string
+	robot.Hear("^\\d$", func(r *marvin.Request) {
+		time.Sleep(time.Millisecond)
+		received = append(received, r.Message.Text)
+	})
+
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		adapter.PushMessage(newTestMessage("1234", text))
+	}
+
+	flush(t, robot, adapter, "1234")
+
+	if len(received) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(received))
+	}
+
+	for i, text := range received {
+		if text != string('1'+rune(i)) {
+			t.Errorf("Messages were handled out of order: %v", received)
+			break
+		}
+	}
+}
+
+func Test_receiveMessagesConcurrent(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	robot.Hear("^slow$", func(r *marvin.Request) {
+		<-block
+	})
+
+	adapter.PushMessage(newTestMessage("general", "slow"))
+	flush(t, robot, adapter, "random")
+}
+
+func Test_receiveMessagesQueueFull(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.QueueSize = 1
+	robot.Open()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	robot.Hear("^slow$", func(r *marvin.Request) {
+		<-block
+	})
+
+	for i := 0; i < 4; i++ {
+		adapter.PushMessage(newTestMessage("general", "slow"))
+	}
+	flush(t, robot, adapter, "random")
+}
+
+func Test_receiveMessagesPanic(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Workers = 1
+	robot.Open()
+
+	robot.Hear("^panic$", func(r *marvin.Request) {
+		panic("oh noes")
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "panic"))
+	flush(t, robot, adapter, "1234")
+}
+
+// closingAdapter is an adapter that closes its message channel when opened.
+type closingAdapter struct {
+	*mock.Adapter
+}
+
+// Open closes the message channel
+func (a closingAdapter) Open(messages chan<- *marvin.Message) error {
+	close(messages)
+	return nil
+}
+
+func Test_receiveMessagesClosed(t *testing.T) {
+	robot, _ := marvin.NewRobot("marvin", closingAdapter{mock.NewAdapter()}, testAddress)
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	// Receiving a nil message from the closed channel would panic.
+	time.Sleep(20 * time.Millisecond)
+
+	if err := robot.Close(); err != nil {
+		t.Errorf("Close should not have returned an error, got %s", err)
+	}
+}
+
 func TestClose(t *testing.T) {
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 	if err := robot.Close(); err != nil {
 		t.Error("Close should not have returned an error")
 	}
@@ -78,29 +215,179 @@ func TestClose(t *testing.T) {
 
 	adapter = mock.NewAdapter()
 	adapter.SetError(errors.New("oh noes"))
-	robot, _ = marvin.NewRobot("marvin", adapter)
+	robot, _ = marvin.NewRobot("marvin", adapter, testAddress)
 	if err := robot.Close(); err == nil {
 		t.Error("Close should have returned an error")
 	}
 }
 
+func TestCloseShutsDownServer(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	addr := robot.Addr().String()
+	if conn, err := net.Dial("tcp", addr); err != nil {
+		t.Errorf("HTTP server should have been listening, got %s", err)
+	} else {
+		conn.Close()
+	}
+
+	if err := robot.Close(); err != nil {
+		t.Errorf("Close should not have returned an error, got %s", err)
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("HTTP server should have been shut down")
+	}
+}
+
+func TestCloseWaitsForListeners(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	started := make(chan struct{})
+	finished := false
+	robot.Hear("^slow$", func(r *marvin.Request) {
+		close(started)
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "slow"))
+	<-started
+
+	if err := robot.Close(); err != nil {
+		t.Errorf("Close should not have returned an error, got %s", err)
+	}
+
+	if !finished {
+		t.Error("Close should have waited for the listener to finish")
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.ShutdownTimeout = 10 * time.Millisecond
+	robot.Open()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+
+	robot.Hear("^stuck$", func(r *marvin.Request) {
+		close(started)
+		<-block
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "stuck"))
+	<-started
+
+	if err := robot.Close(); err != marvin.ErrShutdownTimeout {
+		t.Errorf("Close should have returned ErrShutdownTimeout, got %v", err)
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+
+	var states []marvin.ConnectionState
+	robot.OnConnectionState(func(state marvin.ConnectionState) {
+		states = append(states, state)
+	})
+
+	if state := robot.ConnectionState(); state != marvin.Disconnected {
+		t.Errorf("Expected to be disconnected before opening, got %s", state)
+	}
+
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	if state := robot.ConnectionState(); state != marvin.Connected {
+		t.Errorf("Expected to be connected after opening, got %s", state)
+	}
+
+	robot.Close()
+
+	if state := robot.ConnectionState(); state != marvin.Disconnected {
+		t.Errorf("Expected to be disconnected after closing, got %s", state)
+	}
+
+	if len(states) != 2 || states[0] != marvin.Connected || states[1] != marvin.Disconnected {
+		t.Errorf("Expected the handler to see connected and disconnected, got %v", states)
+	}
+}
+
+// mountingAdapter is a mock adapter that serves an HTTP endpoint.
+type mountingAdapter struct {
+	*mock.Adapter
+}
+
+// Mount mounts the adapter's endpoint.
+func (a *mountingAdapter) Mount(router chi.Router) {
+	router.Get("/adapter", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mounted"))
+	})
+}
+
+func TestMount(t *testing.T) {
+	robot, _ := marvin.NewRobot("marvin", &mountingAdapter{mock.NewAdapter()}, testAddress)
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+	defer robot.Close()
+
+	w := httptest.NewRecorder()
+	robot.Router.ServeHTTP(w, httptest.NewRequest("GET", "/adapter", nil))
+	if w.Body.String() != "mounted" {
+		t.Errorf("The adapter's endpoint should have been mounted, got %q", w.Body.String())
+	}
+}
+
 func TestHear(t *testing.T) {
 	cb := func(*marvin.Request) {}
 
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
-	if err := robot.Hear("test", cb); err != nil {
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	if _, err := robot.Hear("test", cb); err != nil {
 		t.Error("Hear should not have returned an error")
 	}
 
-	if err := robot.Hear("^te[st", cb); err == nil {
+	if _, err := robot.Hear("^te[st", cb); err == nil {
 		t.Error("Hear should have returned an error")
 	}
 }
 
+func TestWithTimeout(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var deadline time.Time
+	var ok bool
+	robot.Hear("^test$", func(r *marvin.Request) {
+		deadline, ok = r.Context().Deadline()
+	}, marvin.WithTimeout(time.Minute))
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	flush(t, robot, adapter, "1234")
+
+	if !ok || deadline.Sub(time.Now()) > time.Minute {
+		t.Error("Request context should have had a deadline set by the listener's timeout")
+	}
+}
+
 func TestOpen(t *testing.T) {
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 	if err := robot.Open(); err != nil {
 		t.Error("Open should not have returned an error")
 	}
@@ -111,17 +398,35 @@ func TestOpen(t *testing.T) {
 
 	adapter = mock.NewAdapter()
 	adapter.SetError(errors.New("oh noes"))
-	robot, _ = marvin.NewRobot("marvin", adapter)
+	robot, _ = marvin.NewRobot("marvin", adapter, testAddress)
 	if err := robot.Open(); err == nil {
 		t.Error("Open should have returned an error")
 	}
+
+	if robot.Context().Err() == nil {
+		t.Error("The robot's context should have been cancelled when the adapter failed to open")
+	}
+
+	if err := robot.Open(); err != marvin.ErrRobotClosed {
+		t.Errorf("Opening the robot again should have returned ErrRobotClosed, got %v", err)
+	}
+
+	adapter = mock.NewAdapter()
+	robot, _ = marvin.NewRobot("marvin", adapter, "256.0.0.1:0")
+	if err := robot.Open(); err == nil {
+		t.Error("Open should have returned an error for an invalid address")
+	}
+
+	if adapter.OpenCalled {
+		t.Error("Open should not have been called on adapter when listening failed")
+	}
 }
 
 func TestRegisterPlugin(t *testing.T) {
 	pluginCalled := false
 
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
 	robot.RegisterPlugin(func(r *marvin.Robot) {
 		pluginCalled = true
 
@@ -129,6 +434,7 @@ func TestRegisterPlugin(t *testing.T) {
 			t.Error("Did not get passed the correct robot")
 		}
 	})
+	robot.Open()
 
 	if !pluginCalled {
 		t.Error("Plugin did not get called")
@@ -139,12 +445,326 @@ func TestRespond(t *testing.T) {
 	cb := func(*marvin.Request) {}
 
 	adapter := mock.NewAdapter()
-	robot, _ := marvin.NewRobot("marvin", adapter)
-	if err := robot.Respond("test", cb); err != nil {
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	if _, err := robot.Respond("test", cb); err != nil {
 		t.Error("Respond should not have returned an error")
 	}
 
-	if err := robot.Respond("^te[st", cb); err == nil {
+	if _, err := robot.Respond("^te[st", cb); err == nil {
 		t.Error("Respond should have returned an error")
 	}
 }
+
+func TestUse(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var calls []string
+	middleware := func(name string) marvin.ListenerMiddleware {
+		return func(next marvin.ListenerHandler) marvin.ListenerHandler {
+			return func(r *marvin.Request) error {
+				calls = append(calls, name)
+				return next(r)
+			}
+		}
+	}
+
+	robot.Use(middleware("first"), middleware("second"))
+	robot.Hear("^test$", func(r *marvin.Request) {
+		calls = append(calls, "callback")
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	adapter.PushMessage(newTestMessage("1234", "unmatched"))
+	flush(t, robot, adapter, "1234")
+
+	// The unmatched message never reaches the middleware, the flush message does.
+	expected := []string{"first", "second", "callback", "first", "second"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestUseReceive(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	robot.UseReceive(func(next marvin.MessageHandler) marvin.MessageHandler {
+		return func(m *marvin.Message) {
+			if m.Text == "drop" {
+				return
+			}
+
+			if m.Text == "rewrite" {
+				m.Text = "test"
+			}
+
+			next(m)
+		}
+	})
+
+	var received []string
+	robot.Hear(".", func(r *marvin.Request) {
+		received = append(received, r.Message.Text)
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "drop"))
+	adapter.PushMessage(newTestMessage("1234", "rewrite"))
+	flush(t, robot, adapter, "1234")
+
+	if len(received) != 2 || received[0] != "test" || received[1] != "__flush__" {
+		t.Errorf("Receive middleware did not drop or rewrite messages, got %v", received)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var errs []error
+	robot.OnError(func(r *marvin.Request, err error) {
+		errs = append(errs, err)
+		r.Reply("Sorry, something went wrong.")
+	})
+
+	oops := errors.New("oops")
+	robot.HearHandler("^fail$", func(r *marvin.Request) error {
+		return oops
+	})
+	robot.HearHandler("^panic$", func(r *marvin.Request) error {
+		panic("oh noes")
+	})
+	robot.HearHandler("^ok$", func(r *marvin.Request) error {
+		return nil
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "fail"))
+	adapter.PushMessage(newTestMessage("1234", "panic"))
+	adapter.PushMessage(newTestMessage("1234", "ok"))
+	flush(t, robot, adapter, "1234")
+
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+
+	if errs[0] != oops {
+		t.Errorf("Expected the returned error, got %v", errs[0])
+	}
+
+	if perr, ok := errs[1].(*marvin.PanicError); !ok || perr.Value != "oh noes" {
+		t.Errorf("Expected a *PanicError, got %v", errs[1])
+	}
+
+	if !adapter.ReplyCalled {
+		t.Error("Error handler should have been able to reply")
+	}
+}
+
+func TestRespondHandler(t *testing.T) {
+	cb := func(*marvin.Request) error { return nil }
+
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	if _, err := robot.RespondHandler("test", cb); err != nil {
+		t.Error("RespondHandler should not have returned an error")
+	}
+
+	if _, err := robot.RespondHandler("^te[st", cb); err == nil {
+		t.Error("RespondHandler should have returned an error")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var calls []string
+	listener := func(name string) marvin.ListenerCallback {
+		return func(r *marvin.Request) {
+			calls = append(calls, name)
+		}
+	}
+
+	robot.Hear("test", listener("low"), marvin.WithPriority(-1))
+	robot.Hear("test", listener("default"))
+	robot.Hear("test", listener("high"), marvin.WithPriority(10))
+	robot.Hear("test", listener("default2"))
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	flush(t, robot, adapter, "1234")
+
+	expected := []string{"high", "default", "default2", "low"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var calls []string
+	robot.Respond("^deploy", func(r *marvin.Request) {
+		calls = append(calls, "respond")
+	}, marvin.Exclusive(), marvin.WithPriority(1))
+	robot.Hear("deploy", func(r *marvin.Request) {
+		calls = append(calls, "hear")
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "marvin deploy"))
+	adapter.PushMessage(newTestMessage("1234", "no deploy"))
+	flush(t, robot, adapter, "1234")
+
+	if len(calls) != 2 || calls[0] != "respond" || calls[1] != "hear" {
+		t.Errorf("Exclusive listener should have stopped the catch-all, got %v", calls)
+	}
+}
+
+func TestStop(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var calls []string
+	robot.Hear("test", func(r *marvin.Request) {
+		calls = append(calls, "first")
+		r.Stop()
+	})
+	robot.Hear("test", func(r *marvin.Request) {
+		calls = append(calls, "second")
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	flush(t, robot, adapter, "1234")
+
+	if len(calls) != 1 || calls[0] != "first" {
+		t.Errorf("Stop should have prevented further listeners, got %v", calls)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var unknown []string
+	robot.Fallback(func(r *marvin.Request) error {
+		unknown = append(unknown, r.Query[0])
+		return nil
+	})
+	robot.Respond("^known$", func(r *marvin.Request) {})
+
+	adapter.PushMessage(newTestMessage("1234", "marvin known"))
+	adapter.PushMessage(newTestMessage("1234", "marvin frobnicate"))
+	adapter.PushMessage(newTestMessage("1234", "frobnicate"))
+	flush(t, robot, adapter, "1234")
+
+	if len(unknown) != 1 || unknown[0] != "frobnicate" {
+		t.Errorf("Fallback should only have handled the unknown direct message, got %v", unknown)
+	}
+}
+
+func TestListenerRemove(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	calls := 0
+	listener, _ := robot.Hear("test", func(r *marvin.Request) {
+		calls++
+	})
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	flush(t, robot, adapter, "1234")
+
+	listener.Remove()
+
+	adapter.PushMessage(newTestMessage("1234", "test"))
+	flush(t, robot, adapter, "1234")
+
+	if calls != 1 {
+		t.Errorf("Removed listener should not have been called, got %d calls", calls)
+	}
+}
+
+func TestListenerDisable(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	var calls []string
+	listener, _ := robot.Hear("test", func(r *marvin.Request) {
+		calls = append(calls, r.Message.Channel.ID)
+	})
+
+	push := func() {
+		for _, channel := range []string{"general", "random"} {
+			adapter.PushMessage(newTestMessage(channel, "test"))
+			flush(t, robot, adapter, channel)
+		}
+	}
+
+	listener.Disable()
+	push()
+
+	listener.Enable()
+	listener.DisableIn("random")
+	push()
+
+	listener.EnableIn("random")
+	push()
+
+	expected := []string{"general", "general", "random"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestListenerConcurrentModification(t *testing.T) {
+	adapter := mock.NewAdapter()
+	robot, _ := marvin.NewRobot("marvin", adapter, testAddress)
+	robot.Open()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			listener, _ := robot.Hear("test", func(r *marvin.Request) {})
+			listener.DisableIn("1234")
+			listener.Disable()
+			listener.Enable()
+			listener.Remove()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		adapter.PushMessage(newTestMessage("1234", "test"))
+	}
+
+	<-done
+	flush(t, robot, adapter, "1234")
+}
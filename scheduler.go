@@ -0,0 +1,340 @@
+package marvin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	mathrand "math/rand"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// schedulerNamespace is the brain namespace one-off jobs are stored in.
+const schedulerNamespace = "scheduler"
+
+// Job describes a recurring job created with Robot.Schedule.
+type Job struct {
+	cancel   context.CancelFunc
+	ctx      context.Context
+	fn       func(context.Context)
+	jitter   time.Duration
+	location *time.Location
+	rand     *mathrand.Rand
+	running  int32
+	schedule schedule
+	spec     string
+}
+
+// Next returns the first time after t the job is scheduled to run, before
+// jitter is applied, or the zero time if it never runs again.
+func (j *Job) Next(t time.Time) time.Time {
+	return j.schedule.next(t.In(j.location))
+}
+
+// Stop stops the job. A run that is in progress is not interrupted.
+func (j *Job) Stop() {
+	j.cancel()
+}
+
+// JobFunc describes the signature of a function running one-off jobs of
+// a kind. It is passed the data the job was scheduled with.
+type JobFunc func(ctx context.Context, data json.RawMessage) error
+
+// ScheduleOption describes an option that can be passed when scheduling a job.
+type ScheduleOption func(*Job)
+
+// WithJitter delays every run of the job by a random duration up to d, so
+// that jobs scheduled for the same time do not all run at once.
+func WithJitter(d time.Duration) ScheduleOption {
+	return func(j *Job) {
+		j.jitter = d
+	}
+}
+
+// WithLocation sets the time zone the job's cron expression is evaluated
+// in. It defaults to the local time zone, and is overridden by a
+// `CRON_TZ=` prefix in the expression.
+func WithLocation(loc *time.Location) ScheduleOption {
+	return func(j *Job) {
+		j.location = loc
+	}
+}
+
+// oneOffJob describes a one-off job as stored in the brain.
+type oneOffJob struct {
+	At   time.Time       `json:"at"`
+	Data json.RawMessage `json:"data"`
+	ID   string          `json:"id"`
+	Kind string          `json:"kind"`
+}
+
+// Schedule runs fn according to the given cron expression until the job is
+// stopped or the robot is closed. The expression has five fields, minute,
+// hour, day of month, month and day of week, as in `30 9 * * mon-fri`.
+// Descriptors such as `@daily` and intervals such as `@every 10m` are also
+// accepted. A run is skipped if the previous one has not finished yet. Jobs
+// scheduled before the robot is opened start running when it is.
+func (r *Robot) Schedule(spec string, fn func(context.Context), options ...ScheduleOption) (*Job, error) {
+	sched, loc, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	job := &Job{
+		cancel:   cancel,
+		ctx:      ctx,
+		fn:       fn,
+		location: time.Local,
+		rand:     mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+		schedule: sched,
+		spec:     spec,
+	}
+	for _, option := range options {
+		option(job)
+	}
+
+	if loc != nil {
+		job.location = loc
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.opened {
+		r.pendingJobs = append(r.pendingJobs, job)
+	} else if r.ctx.Err() == nil {
+		r.jobs.Add(1)
+		go r.runSchedule(job)
+	}
+
+	return job, nil
+}
+
+// runSchedule waits for each of the job's scheduled times and runs it,
+// until the job's context is cancelled.
+func (r *Robot) runSchedule(job *Job) {
+	defer r.jobs.Done()
+
+	ctx := job.ctx
+
+	for {
+		next := job.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		if job.jitter > 0 {
+			next = next.Add(time.Duration(job.rand.Int63n(int64(job.jitter))))
+		}
+
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+			log.Printf("marvin: skipping job %q, as its previous run has not finished", job.spec)
+			continue
+		}
+
+		r.jobs.Add(1)
+		go func() {
+			defer r.jobs.Done()
+			defer atomic.StoreInt32(&job.running, 0)
+			defer func() {
+				if v := recover(); v != nil {
+					log.Printf("marvin: job %q panicked: %v\n%s", job.spec, v, debug.Stack())
+				}
+			}()
+
+			job.fn(ctx)
+		}()
+	}
+}
+
+// At schedules a one-off job of the given kind to run at t, passing it data
+// encoded as JSON. The job is stored in the robot's brain, so it survives a
+// restart; jobs that were due while the robot was down run when it is
+// opened. It returns the ID of the job, which can be used to cancel it.
+//
+// A job is removed from the brain just before it runs, so it runs at most
+// once: a job that is interrupted, because it fails or the robot stops, is
+// not run again.
+func (r *Robot) At(t time.Time, kind string, data interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	job := &oneOffJob{At: t, Data: raw, ID: id, Kind: kind}
+	if err := r.Namespace(schedulerNamespace).Set(id, job); err != nil {
+		return "", err
+	}
+
+	r.armJob(job)
+	return id, nil
+}
+
+// CancelJob cancels the one-off job with the given ID.
+func (r *Robot) CancelJob(id string) error {
+	r.mu.Lock()
+	if timer, ok := r.timers[id]; ok {
+		timer.Stop()
+		delete(r.timers, id)
+	}
+	r.mu.Unlock()
+
+	return r.Namespace(schedulerNamespace).Delete(id)
+}
+
+// RegisterJob registers the function running one-off jobs of the given
+// kind. Stored jobs of that kind that were due already run right away.
+func (r *Robot) RegisterJob(kind string, fn JobFunc) {
+	r.mu.Lock()
+	r.jobFuncs[kind] = fn
+	opened := r.opened
+	r.mu.Unlock()
+
+	if !opened {
+		return
+	}
+
+	if err := r.loadJobs(); err != nil {
+		log.Printf("marvin: error loading jobs of kind %q: %s", kind, err)
+	}
+}
+
+// armJob sets a timer for the one-off job, unless one is already set or the
+// robot is not open; loadJobs arms the job once it is.
+func (r *Robot) armJob(job *oneOffJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.timers[job.ID]; ok || !r.opened || r.ctx.Err() != nil {
+		return
+	}
+
+	r.timers[job.ID] = time.AfterFunc(job.At.Sub(time.Now()), func() {
+		r.runJob(job.ID)
+	})
+}
+
+// loadJobs arms the one-off jobs stored in the robot's brain.
+func (r *Robot) loadJobs() error {
+	ns := r.Namespace(schedulerNamespace)
+
+	ids, err := ns.Keys("")
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		job := &oneOffJob{}
+		if err := ns.Get(id, job); err != nil {
+			return err
+		}
+
+		r.armJob(job)
+	}
+
+	return nil
+}
+
+// runJob removes the one-off job with the given ID from the brain and runs
+// it. Jobs of an unknown kind are left in place, so that they run once a
+// function for their kind is registered.
+func (r *Robot) runJob(id string) {
+	r.mu.Lock()
+	delete(r.timers, id)
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		return
+	}
+	r.jobs.Add(1)
+	r.mu.Unlock()
+
+	defer r.jobs.Done()
+
+	ns := r.Namespace(schedulerNamespace)
+
+	job := &oneOffJob{}
+	if err := ns.Get(id, job); err != nil {
+		// The job was cancelled in the meantime.
+		return
+	}
+
+	r.mu.RLock()
+	fn, ok := r.jobFuncs[job.Kind]
+	r.mu.RUnlock()
+
+	if !ok {
+		log.Printf("marvin: no function registered for job %s of kind %q", id, job.Kind)
+		return
+	}
+
+	if err := ns.Delete(id); err != nil {
+		log.Printf("marvin: error removing job %s: %s", id, err)
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("marvin: job %s of kind %q panicked: %v\n%s", id, job.Kind, v, debug.Stack())
+		}
+	}()
+
+	if err := fn(r.ctx, job.Data); err != nil {
+		log.Printf("marvin: error running job %s of kind %q: %s", id, job.Kind, err)
+	}
+}
+
+// startJobs starts the recurring jobs scheduled before the robot was opened,
+// and arms the one-off jobs stored in the brain.
+func (r *Robot) startJobs() error {
+	r.mu.Lock()
+	r.opened = true
+	pending := r.pendingJobs
+	r.pendingJobs = nil
+
+	if r.ctx.Err() == nil {
+		for _, job := range pending {
+			r.jobs.Add(1)
+			go r.runSchedule(job)
+		}
+	}
+	r.mu.Unlock()
+
+	return r.loadJobs()
+}
+
+// stopJobs stops the timers of all armed one-off jobs.
+func (r *Robot) stopJobs() {
+	r.mu.Lock()
+	for id, timer := range r.timers {
+		timer.Stop()
+		delete(r.timers, id)
+	}
+	r.mu.Unlock()
+}
+
+// newJobID returns a random job ID.
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
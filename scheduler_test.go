@@ -0,0 +1,169 @@
+package marvin_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chielkunkels/marvin"
+	"github.com/chielkunkels/marvin/mock"
+)
+
+func TestScheduleNext(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	robot, _ := marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+	defer robot.Close()
+
+	// Saturday 30 December 2017, 10:15 UTC.
+	now := time.Date(2017, time.December, 30, 10, 15, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2017, time.December, 30, 10, 16, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2017, time.December, 30, 10, 20, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2018, time.January, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 15 * 0", time.Date(2017, time.December, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2017, time.December, 31, 12, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2017, time.December, 30, 11, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2017, time.December, 30, 11, 45, 30, 0, time.UTC)},
+		{"CRON_TZ=Europe/Amsterdam 0 12 * * *", time.Date(2017, time.December, 30, 12, 0, 0, 0, amsterdam)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		job, err := robot.Schedule(test.spec, func(context.Context) {}, marvin.WithLocation(time.UTC))
+		if err != nil {
+			t.Errorf("%s: Schedule should not have returned an error, got %s", test.spec, err)
+			continue
+		}
+		job.Stop()
+
+		if next := job.Next(now); !next.Equal(test.expected) {
+			t.Errorf("%s: expected next run at %s, got %s", test.spec, test.expected, next)
+		}
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	robot, _ := marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+	defer robot.Close()
+
+	specs := []string{"", "* * * *", "60 * * * *", "* * * foo *", "5-1 * * * *", "*/0 * * * *", "@every soon", "CRON_TZ=Nowhere/Special * * * * *"}
+	for _, spec := range specs {
+		if _, err := robot.Schedule(spec, func(context.Context) {}); err == nil {
+			t.Errorf("Schedule should have returned an error for %q", spec)
+		}
+	}
+}
+
+func TestScheduleSkipsWhileRunning(t *testing.T) {
+	robot, _ := marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+
+	runs := make(chan struct{}, 10)
+	release := make(chan struct{})
+	job, _ := robot.Schedule("@every 10ms", func(ctx context.Context) {
+		runs <- struct{}{}
+		<-release
+	})
+
+	// Jobs only start running once the robot is opened.
+	time.Sleep(30 * time.Millisecond)
+	if len(runs) != 0 {
+		t.Fatal("Expected the job not to run before the robot was opened")
+	}
+
+	robot.Open()
+	time.Sleep(100 * time.Millisecond)
+	job.Stop()
+	close(release)
+
+	if err := robot.Close(); err != nil {
+		t.Errorf("Close should not have returned an error, got %s", err)
+	}
+
+	if len(runs) != 1 {
+		t.Errorf("Expected runs to be skipped while the job was running, got %d runs", len(runs))
+	}
+}
+
+func TestAt(t *testing.T) {
+	brain := marvin.NewMemoryBrain()
+
+	robot, _ := marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+	robot.Brain = brain
+
+	robot.At(time.Now().Add(-time.Minute), "greet", "ford")
+	robot.At(time.Now().Add(time.Hour), "greet", "zaphod")
+	cancelled, _ := robot.At(time.Now().Add(-time.Minute), "greet", "arthur")
+	robot.CancelJob(cancelled)
+	robot.Close()
+
+	// Reopen with the same brain, as if the process had restarted.
+	robot, _ = marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+	robot.Brain = brain
+	defer robot.Close()
+
+	greeted := make(chan string, 10)
+	robot.RegisterJob("greet", func(ctx context.Context, data json.RawMessage) error {
+		var name string
+		json.Unmarshal(data, &name)
+		greeted <- name
+		return nil
+	})
+
+	if err := robot.Open(); err != nil {
+		t.Fatalf("Open should not have returned an error, got %s", err)
+	}
+
+	select {
+	case name := <-greeted:
+		if name != "ford" {
+			t.Errorf("Expected the overdue job to run, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the overdue job to run")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(greeted) != 0 {
+		t.Errorf("Expected only the overdue job to run, got %s", <-greeted)
+	}
+
+	if keys, _ := robot.Namespace("scheduler").Keys(""); len(keys) != 1 {
+		t.Errorf("Expected only the pending job to remain stored, got %v", keys)
+	}
+}
+
+func TestAtUnknownKind(t *testing.T) {
+	robot, _ := marvin.NewRobot("marvin", mock.NewAdapter(), testAddress)
+	robot.Open()
+	defer robot.Close()
+
+	robot.At(time.Now(), "greet", "ford")
+	time.Sleep(50 * time.Millisecond)
+
+	greeted := make(chan string, 1)
+	robot.RegisterJob("greet", func(ctx context.Context, data json.RawMessage) error {
+		var name string
+		json.Unmarshal(data, &name)
+		greeted <- name
+		return nil
+	})
+
+	select {
+	case name := <-greeted:
+		if name != "ford" {
+			t.Errorf("Expected the stored job to run, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the job to run once its kind was registered")
+	}
+}